@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -28,29 +29,6 @@ const maxOutputSizeBytes = 50_000_000  // 50mb
 const maxFileReadSizeBytes = 2_000_000 // 2mb
 var ErrContextTooLong = errors.New("context is too long")
 
-// directories that should never appear in the generated context tree
-var alwaysExcludedDirs = map[string]bool{
-	".git": true,
-	"node_modules": true,
-	".svn": true,
-	".hg": true,
-	".bzr": true,
-	"vendor": true,
-	".idea": true,
-	".vscode": true,
-	"__pycache__": true,
-	".pytest_cache": true,
-	".mypy_cache": true,
-	"target": true,  // rust/java
-	"dist": true,
-	"build": true,
-	".next": true,
-	".nuxt": true,
-	".cache": true,
-	"coverage": true,
-	".nyc_output": true,
-}
-
 //go:embed ignore.glob
 var defaultCustomIgnoreRulesContent string
 
@@ -63,6 +41,11 @@ type AppSettings struct {
 	CustomIgnoreRules string `json:"customIgnoreRules"`
 	CustomPromptRules string `json:"customPromptRules"`
 	GeminiAPIKey      string `json:"geminiApiKey"`
+	SymlinkPolicy     string `json:"symlinkPolicy,omitempty"` // "skip", "show" (default) or "follow", see symlinks.go
+	// directory names that are never shown, see hardexclude.go. nil means the built-in
+	// list, an empty list shows every directory.
+	HardExcludedDirs        []string            `json:"hardExcludedDirs"`
+	ProjectHardExcludedDirs map[string][]string `json:"projectHardExcludedDirs,omitempty"` // by project path, replacing the global list
 }
 
 type App struct {
@@ -74,13 +57,22 @@ type App struct {
 	configPath                  string
 	useGitignore                bool
 	useCustomIgnore             bool
-	projectGitignore            *gitignore.GitIgnore // compiled .gitignore for the current project
-	geminiRequestCancel         context.CancelFunc   // cancel function for gemini request
+	projectGitignores           map[string]*gitIgnoreMatcher // git ignore rules (.gitignore files, info/exclude, excludesfile) by root of the current project
+	contextStore                *contextStore                // generated contexts on disk, see context_store.go
+	contentCache                *contentCache                // processed file blocks of the last context, see contentcache.go
+	snapshots                   *snapshotStore               // file hashes of recent contexts for delta contexts, see delta.go
+	virtualTrees                *virtualTreeCache            // archives and git refs opened as roots, see virtualtree.go
+	geminiRequestCancel         context.CancelFunc           // cancel function for gemini request
 
 	// defaultrootdir holds an optional folder path passed via command line argument (e.g. when a user
 	// drags a folder onto the compiled executable). if set, the app will emit an event on startup so
 	// it can open the folder automatically.
 	defaultRootDir string
+
+	// headless is set when the app runs as a command line tool without a wails window.
+	// log lines then go to the headless logger and events to onevent (see logging.go).
+	headless *headlessLogger
+	onEvent  func(eventName string, optionalData ...interface{})
 }
 
 func NewApp() *App {
@@ -88,36 +80,26 @@ func NewApp() *App {
 }
 
 func (a *App) startup(ctx context.Context) {
-	a.ctx = ctx
-	a.contextGenerator = NewContextGenerator(a)
-	a.fileWatcher = NewWatchman(a)
-	a.useGitignore = true    // default to true, matching frontend
-	a.useCustomIgnore = true // default to true, matching frontend
+	a.initState(ctx)
 
-	configFilePath, err := xdg.ConfigFile("shotgun-code/settings.json")
+	store, err := newContextStore()
 	if err != nil {
-		runtime.LogErrorf(a.ctx, "error getting config file path: %v. using defaults and will attempt to save later if rules are modified.", err)
-		// configpath will be empty, loadsettings will handle this by using defaults
-		// and savesettings will fail gracefully if configpath remains empty and saving is attempted.
-	}
-	a.configPath = configFilePath
-
-	a.loadSettings()
-	// ensure custompromptrules has a default if it's empty after loading
-	if strings.TrimSpace(a.settings.CustomPromptRules) == "" {
-		a.settings.CustomPromptRules = defaultCustomPromptRulesContent
+		a.logErrorf("startup: %v. generated contexts cannot be stored.", err)
 	}
+	a.contextStore = store
+	a.contentCache = newContentCache() // the headless mode generates once, so only the gui keeps one
+	a.snapshots = newSnapshotStore()
 
 	// if a default root directory was provided we will emit an auto-open event
 	// after the frontend is fully ready (see domready). here we just set the
 	// window title early for better ux.
 	if a.defaultRootDir != "" {
-		if info, err := os.Stat(a.defaultRootDir); err == nil && info.IsDir() {
+		if info, err := os.Stat(a.defaultRootDir); err == nil && (info.IsDir() || isArchivePath(a.defaultRootDir)) {
 			folderName := filepath.Base(a.defaultRootDir)
 			title := fmt.Sprintf("%s - shotgun", folderName)
 			runtime.WindowSetTitle(a.ctx, title)
 		} else {
-			runtime.LogWarningf(a.ctx, "startup: provided defaultRootDir '%s' is invalid: %v", a.defaultRootDir, err)
+			a.logWarningf("startup: provided defaultRootDir '%s' is invalid: %v", a.defaultRootDir, err)
 			// invalidate if not valid
 			a.defaultRootDir = ""
 		}
@@ -125,10 +107,42 @@ func (a *App) startup(ctx context.Context) {
 
 	// register global file-drop handler to log the absolute paths; frontend handles ui.
 	runtime.OnFileDrop(a.ctx, func(x, y int, paths []string) {
-		runtime.LogDebugf(a.ctx, "onfiledrop at %dx%d -> %v", x, y, paths)
+		a.logDebugf("onfiledrop at %dx%d -> %v", x, y, paths)
 	})
 }
 
+// initstate sets up everything the app needs that does not depend on a wails window.
+// it is shared by the gui startup and the headless command line mode.
+func (a *App) initState(ctx context.Context) {
+	a.ctx = ctx
+	a.contextGenerator = NewContextGenerator(a)
+	a.fileWatcher = NewWatchman(a)
+	a.virtualTrees = newVirtualTreeCache()
+	a.useGitignore = true    // default to true, matching frontend
+	a.useCustomIgnore = true // default to true, matching frontend
+
+	configFilePath, err := xdg.ConfigFile("shotgun-code/settings.json")
+	if err != nil {
+		a.logErrorf("error getting config file path: %v. using defaults and will attempt to save later if rules are modified.", err)
+		// configpath will be empty, loadsettings will handle this by using defaults
+		// and savesettings will fail gracefully if configpath remains empty and saving is attempted.
+	}
+	a.configPath = configFilePath
+
+	a.loadSettings()
+	// ensure custompromptrules has a default if it's empty after loading
+	if strings.TrimSpace(a.settings.CustomPromptRules) == "" {
+		a.settings.CustomPromptRules = defaultCustomPromptRulesContent
+	}
+}
+
+// shutdown is called by wails when the application is about to quit.
+func (a *App) shutdown(ctx context.Context) {
+	if a.contextStore != nil {
+		a.contextStore.removeAll()
+	}
+}
+
 // domready is called by wails when the frontend has finished loading and the js
 // runtime is ready. only at this point are event listeners on the js side able
 // to receive events, so we emit the auto-open-folder event here.
@@ -145,8 +159,11 @@ type FileNode struct {
 	RelPath         string      `json:"relPath"` // path relative to selected root
 	IsDir           bool        `json:"isDir"`
 	Children        []*FileNode `json:"children,omitempty"`
-	IsGitignored    bool        `json:"isGitignored"`    // true if path matches a .gitignore rule
-	IsCustomIgnored bool        `json:"isCustomIgnored"` // true if path matches a ignore.glob rule
+	IsGitignored    bool        `json:"isGitignored"`            // true if path matches a .gitignore rule
+	IsCustomIgnored bool        `json:"isCustomIgnored"`         // true if path matches a ignore.glob rule
+	GitStatus       string      `json:"gitStatus,omitempty"`     // "modified", "untracked", ... for files changed since head
+	SymlinkTarget   string      `json:"symlinkTarget,omitempty"` // set for symbolic links: the target as stored in the link
+	SymlinkNote     string      `json:"symlinkNote,omitempty"`   // why a link was not followed ("outside root", "broken link", "cycle")
 }
 
 // selectdirectory opens a dialog to select a directory and returns the chosen path (empty string on cancel)
@@ -158,7 +175,7 @@ func (a *App) SelectDirectory() (string, error) {
 	// frontend does not show a scary error message.
 	if err != nil && dirPath == "" {
 		// optionally log at debug level for diagnostics
-		runtime.LogDebugf(a.ctx, "selectdirectory: dialog closed without selection: %v", err)
+		a.logDebugf("selectdirectory: dialog closed without selection: %v", err)
 		return "", nil
 	}
 
@@ -175,75 +192,45 @@ func (a *App) SelectDirectory() (string, error) {
 	return dirPath, nil
 }
 
-// listfiles lists files and folders in a directory, parsing .gitignore if present
+// listfiles lists files and folders in a directory, or the entries of a zip or tar
+// archive (see archive.go), parsing .gitignore if present
 func (a *App) ListFiles(dirPath string) ([]*FileNode, error) {
-	runtime.LogDebugf(a.ctx, "listfiles called for directory: %s", dirPath)
-	runtime.LogDebugf(a.ctx, "listfiles: useCustomIgnore=%v, customPatternsLoaded=%v", 
+	a.logDebugf("listfiles called for directory: %s", dirPath)
+	a.logDebugf("listfiles: useCustomIgnore=%v, customPatternsLoaded=%v", 
 		a.useCustomIgnore, a.currentCustomIgnorePatterns != nil)
 
-	a.projectGitignore = nil        // reset for the new directory
-	var gitIgn *gitignore.GitIgnore // for .gitignore in the project directory
-	gitignorePath := filepath.Join(dirPath, ".gitignore")
-	runtime.LogDebugf(a.ctx, "attempting to find .gitignore at: %s", gitignorePath)
-	if _, err := os.Stat(gitignorePath); err == nil {
-		runtime.LogDebugf(a.ctx, ".gitignore found at: %s", gitignorePath)
-		gitIgn, err = gitignore.CompileIgnoreFile(gitignorePath)
-		if err != nil {
-			runtime.LogWarningf(a.ctx, "error compiling .gitignore file at %s: %v", gitignorePath, err)
-			gitIgn = nil
-		} else {
-			a.projectGitignore = gitIgn // store the compiled project-specific gitignore
-			runtime.LogDebug(a.ctx, ".gitignore compiled successfully.")
-		}
-	} else {
-		runtime.LogDebugf(a.ctx, ".gitignore not found at %s (os.stat error: %v)", gitignorePath, err)
-		gitIgn = nil
-	}
-
 	// app-level custom ignore patterns are in a.currentcustomignorepatterns
 	if a.currentCustomIgnorePatterns != nil {
-		runtime.LogDebugf(a.ctx, "custom ignore patterns are active and will be applied")
+		a.logDebugf("custom ignore patterns are active and will be applied")
 	} else {
-		runtime.LogDebugf(a.ctx, "no custom ignore patterns to apply")
-	}
-
-	rootNode := &FileNode{
-		Name:         filepath.Base(dirPath),
-		Path:         dirPath,
-		RelPath:      ".",
-		IsDir:        true,
-		IsGitignored: false, // root itself is not gitignored by default
-		// iscustomignored for root is also false by default, specific patterns would be needed
-		IsCustomIgnored: a.currentCustomIgnorePatterns != nil && a.currentCustomIgnorePatterns.MatchesPath("."),
-	}
-
-	children, err := buildTreeRecursive(context.TODO(), dirPath, dirPath, gitIgn, a.currentCustomIgnorePatterns, 0, false, false)
-	if err != nil {
-		return []*FileNode{rootNode}, fmt.Errorf("error building children tree for %s: %w", dirPath, err)
+		a.logDebugf("no custom ignore patterns to apply")
 	}
-	rootNode.Children = children
 
-	return []*FileNode{rootNode}, nil
+	// git ignore rules: every .gitignore in the tree (scoped to its directory), plus
+	// .git/info/exclude and core.excludesfile when the folder is inside a repository.
+	rootNode, gitIgn, err := a.listRoot(WorkspaceRoot{Path: dirPath})
+	a.projectGitignores = map[string]*gitIgnoreMatcher{dirPath: gitIgn} // store the project-specific matcher for the watcher
+	return []*FileNode{rootNode}, err
 }
 
-func buildTreeRecursive(ctx context.Context, currentPath, rootPath string, gitIgn *gitignore.GitIgnore, customIgn *gitignore.GitIgnore, depth int, inheritedGitIgnored, inheritedCustomIgnored bool) ([]*FileNode, error) {
+func (a *App) buildTreeRecursive(ctx context.Context, walker *symlinkWalker, currentPath, rootPath string, gitIgn *gitIgnoreMatcher, customIgn *gitignore.GitIgnore, depth int, inheritedGitIgnored, inheritedCustomIgnored bool) ([]*FileNode, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
 
-	entries, err := os.ReadDir(currentPath)
+	entries, err := walker.readDir(currentPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var nodes []*FileNode
 	for _, entry := range entries {
-		nodePath := filepath.Join(currentPath, entry.Name())
+		nodePath := entry.path
 		relPath, _ := filepath.Rel(rootPath, nodePath)
-		// for gitignore matching, paths should generally be relative to the .gitignore file (rootpath)
-		// and use os-specific separators. go-gitignore handles this.
+		// paths are matched relative to rootpath with os-specific separators; the git matcher
+		// scopes every .gitignore to its own directory, go-gitignore handles the custom rules.
 
 		isGitignored := inheritedGitIgnored
 		isCustomIgnored := inheritedCustomIgnored
@@ -251,51 +238,52 @@ func buildTreeRecursive(ctx context.Context, currentPath, rootPath string, gitIg
 		// Only check ignore patterns if not already inherited from parent
 		if !inheritedGitIgnored || !inheritedCustomIgnored {
 			pathToMatch := relPath
-			if entry.IsDir() {
+			if entry.isDir {
 				if !strings.HasSuffix(pathToMatch, string(os.PathSeparator)) {
 					pathToMatch += string(os.PathSeparator)
 				}
 			}
 
 			if !inheritedGitIgnored && gitIgn != nil {
-				isGitignored = gitIgn.MatchesPath(pathToMatch)
+				isGitignored = gitIgn.match(relPath, entry.isDir)
 			}
 			if !inheritedCustomIgnored && customIgn != nil {
 				isCustomIgnored = customIgn.MatchesPath(pathToMatch)
 				// log matching for debugging (limit to avoid spam)
 				if isCustomIgnored && depth < 3 {
-					// can't use runtime.Log here without app context, so we'll skip detailed logging in recursive function
-					// the logging in ListFiles should be sufficient
+					a.logDebugf("buildtreerecursive: %s matches custom ignore rules", relPath)
 				}
 			}
 		}
 
 		node := &FileNode{
-			Name:            entry.Name(),
+			Name:            entry.name,
 			Path:            nodePath,
 			RelPath:         relPath,
-			IsDir:           entry.IsDir(),
+			IsDir:           entry.isDir,
 			IsGitignored:    isGitignored,
 			IsCustomIgnored: isCustomIgnored,
+			SymlinkTarget:   entry.linkTarget,
+			SymlinkNote:     entry.linkNote,
 		}
 
-		if entry.IsDir() {
-			// skip reading contents of ignored directories to improve performance
-			if !isGitignored && !isCustomIgnored {
+		if entry.isDir {
+			// skip reading contents of ignored directories (and links that are not followed)
+			if !isGitignored && !isCustomIgnored && entry.follow {
 				// if it's a directory, recursively call buildtree
 				// always recurse, but pass down ignore status to children
-				children, err := buildTreeRecursive(ctx, nodePath, rootPath, gitIgn, customIgn, depth+1, isGitignored, isCustomIgnored)
+				children, err := a.buildTreeRecursive(ctx, walker, nodePath, rootPath, gitIgn, customIgn, depth+1, isGitignored, isCustomIgnored)
 				if err != nil {
 					if errors.Is(err, context.Canceled) {
 						return nil, err // propagate cancellation
 					}
-					runtime.LogWarningf(context.Background(), "error building subtree for %s: %v", nodePath, err) // fallback for now
+					a.logWarningf("error building subtree for %s: %v", nodePath, err)
 					// decide: skip this dir or return error up. for now, skip with log.
 				} else {
 					node.Children = children
 				}
 			} else {
-				// directory is ignored, so don't read its contents for performance
+				// directory is ignored or a link that is not followed, so don't read its contents
 				node.Children = []*FileNode{} // empty children array
 			}
 		}
@@ -329,10 +317,11 @@ func NewContextGenerator(app *App) *ContextGenerator {
 // requestshotguncontextgeneration is called by the frontend to start/restart generation.
 // this method itself is not bound to wails directly if it's part of app.
 // instead, a wrapper method in app struct will be bound.
-func (cg *ContextGenerator) requestShotgunContextGenerationInternal(rootDir string, excludedPaths []string) {
+func (cg *ContextGenerator) requestShotgunContextGenerationInternal(roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) {
+	rootDir := roots[0].Path // for the log lines
 	cg.mu.Lock()
 	if cg.currentCancelFunc != nil {
-		runtime.LogDebug(cg.app.ctx, "cancelling previous context generation job.")
+		cg.app.logDebug("cancelling previous context generation job.")
 		cg.currentCancelFunc()
 	}
 
@@ -340,7 +329,7 @@ func (cg *ContextGenerator) requestShotgunContextGenerationInternal(rootDir stri
 	myToken := new(struct{}) // create a unique token for this generation job
 	cg.currentCancelFunc = cancel
 	cg.currentCancelToken = myToken
-	runtime.LogInfof(cg.app.ctx, "starting new shotgun context generation for: %s. max size: %d bytes.", rootDir, maxOutputSizeBytes)
+	cg.app.logInfof("starting new shotgun context generation for: %s. max size: %d bytes.", rootDir, maxOutputSizeBytes)
 	cg.mu.Unlock()
 
 	go func(tokenForThisJob interface{}) {
@@ -350,39 +339,43 @@ func (cg *ContextGenerator) requestShotgunContextGenerationInternal(rootDir stri
 			if cg.currentCancelToken == tokenForThisJob { // only clear if it's still this job's token
 				cg.currentCancelFunc = nil
 				cg.currentCancelToken = nil
-				runtime.LogDebug(cg.app.ctx, "cleared currentcancelfunc for completed/cancelled job (token match).")
+				cg.app.logDebug("cleared currentcancelfunc for completed/cancelled job (token match).")
 			} else {
-				runtime.LogDebug(cg.app.ctx, "currentcancelfunc was replaced by a newer job (token mismatch); not clearing.")
+				cg.app.logDebug("currentcancelfunc was replaced by a newer job (token mismatch); not clearing.")
 			}
 			cg.mu.Unlock()
-			runtime.LogInfof(cg.app.ctx, "shotgun context generation goroutine finished in %s", time.Since(jobStartTime))
+			cg.app.logInfof("shotgun context generation goroutine finished in %s", time.Since(jobStartTime))
 		}()
 
 		if genCtx.Err() != nil { // check for immediate cancellation
-			runtime.LogInfo(cg.app.ctx, fmt.Sprintf("context generation for %s cancelled before starting: %v", rootDir, genCtx.Err()))
+			cg.app.logInfo(fmt.Sprintf("context generation for %s cancelled before starting: %v", rootDir, genCtx.Err()))
 			return
 		}
 
-		output, err := cg.app.generateShotgunOutputWithProgress(genCtx, rootDir, excludedPaths)
+		handle, report, err := cg.app.generateStoredContext(genCtx, roots, excludedPaths, opts)
 
 		select {
 		case <-genCtx.Done():
 			errMsg := fmt.Sprintf("shotgun context generation cancelled for %s: %v", rootDir, genCtx.Err())
-			runtime.LogInfo(cg.app.ctx, errMsg) // changed from logwarn
-			runtime.EventsEmit(cg.app.ctx, "shotgunContextError", errMsg)
+			cg.app.logInfo(errMsg) // changed from logwarn
+			cg.app.emitEvent("shotgunContextError", errMsg)
 		default:
 			if err != nil {
 				errMsg := fmt.Sprintf("error generating shotgun output for %s: %v", rootDir, err)
-				runtime.LogError(cg.app.ctx, errMsg)
-				runtime.EventsEmit(cg.app.ctx, "shotgunContextError", errMsg)
+				cg.app.logError(errMsg)
+				if report != nil { // e.g. the composition of a context that is too long
+					cg.app.emitEvent("shotgunContextReport", report)
+				}
+				cg.app.emitEvent("shotgunContextError", errMsg)
 			} else {
-				finalSize := len(output)
+				finalSize := handle.Size
 				successMsg := fmt.Sprintf("shotgun context generated successfully for %s. size: %d bytes.", rootDir, finalSize)
 				if finalSize > maxOutputSizeBytes { // should have been caught by errcontexttoolong, but as a safeguard
-					runtime.LogWarningf(cg.app.ctx, "warning: generated context size %d exceeds max %d, but was not caught by errcontexttoolong.", finalSize, maxOutputSizeBytes)
+					cg.app.logWarningf("warning: generated context size %d exceeds max %d, but was not caught by errcontexttoolong.", finalSize, maxOutputSizeBytes)
 				}
-				runtime.LogInfo(cg.app.ctx, successMsg)
-				runtime.EventsEmit(cg.app.ctx, "shotgunContextGenerated", output)
+				cg.app.logInfo(successMsg)
+				cg.app.emitEvent("shotgunContextGenerated", handle)
+				cg.app.emitEvent("shotgunContextReport", report)
 			}
 		}
 	}(myToken) // pass the token to the goroutine
@@ -390,19 +383,28 @@ func (cg *ContextGenerator) requestShotgunContextGenerationInternal(rootDir stri
 
 // requestshotguncontextgeneration is the method bound to wails.
 func (a *App) RequestShotgunContextGeneration(rootDir string, excludedPaths []string) {
+	a.RequestShotgunContextGenerationWithOptions(rootDir, excludedPaths, ContextOptions{})
+}
+
+// requestshotguncontextgenerationwithoptions is like requestshotguncontextgeneration but
+// takes per-request options such as a token budget.
+func (a *App) RequestShotgunContextGenerationWithOptions(rootDir string, excludedPaths []string, opts ContextOptions) {
 	if a.contextGenerator == nil {
 		// this should not happen if startup initializes it correctly
-		runtime.LogError(a.ctx, "contextgenerator not initialized")
-		runtime.EventsEmit(a.ctx, "shotgunContextError", "internal error: contextgenerator not initialized")
+		a.logError("contextgenerator not initialized")
+		a.emitEvent("shotgunContextError", "internal error: contextgenerator not initialized")
 		return
 	}
-	a.contextGenerator.requestShotgunContextGenerationInternal(rootDir, excludedPaths)
+	a.contextGenerator.requestShotgunContextGenerationInternal(projectRoots(rootDir), excludedPaths, opts)
 }
 
 // countprocessableitems estimates the total number of operations for progress tracking.
 // optimized version: counts tree entries for all files (including excluded) + file reads for non-excluded only
-func (a *App) countProcessableItems(jobCtx context.Context, rootDir string, excludedMap map[string]bool) (int, error) {
+func (a *App) countProcessableItems(jobCtx context.Context, root WorkspaceRoot, walker *symlinkWalker, selection *contextSelection) (int, error) {
 	count := 1 // for the root directory line itself
+	if selection.excluded[root.prefixed(".")] {
+		return count, nil // a workspace root the user unchecked
+	}
 
 	var counterHelper func(currentPath string, parentExcluded bool) error
 	counterHelper = func(currentPath string, parentExcluded bool) error {
@@ -412,31 +414,26 @@ func (a *App) countProcessableItems(jobCtx context.Context, rootDir string, excl
 		default:
 		}
 
-		entries, err := os.ReadDir(currentPath)
+		entries, err := walker.readDir(currentPath)
 		if err != nil {
-			runtime.LogWarningf(a.ctx, "countprocessableitems: error reading dir %s: %v", currentPath, err)
+			a.logWarningf("countprocessableitems: error reading dir %s: %v", currentPath, err)
 			return nil // continue counting other parts if a subdir is inaccessible
 		}
 
 		for _, entry := range entries {
-			// skip directories that should never be shown
-			if entry.IsDir() && alwaysExcludedDirs[entry.Name()] {
-				continue
-			}
-			
-			path := filepath.Join(currentPath, entry.Name())
-			relPath, _ := filepath.Rel(rootDir, path)
+			path := entry.path
+			relPath := root.relPath(path)
 
 			// count all entries for tree display
 			count++ // for the tree entry (dir or file)
 
 			// determine if this item is excluded
-			isExcluded := parentExcluded || excludedMap[relPath]
+			isExcluded := parentExcluded || selection.excluded[relPath]
 
-			if entry.IsDir() {
+			if entry.isDir {
 				// only recurse into non-excluded directories for performance
 				// excluded directories are counted as single items but their contents are skipped
-				if !isExcluded {
+				if !isExcluded && entry.follow {
 					err := counterHelper(path, isExcluded)
 					if err != nil { // propagate cancellation or critical errors
 						return err
@@ -444,20 +441,20 @@ func (a *App) countProcessableItems(jobCtx context.Context, rootDir string, excl
 				} else {
 					// log first few skipped directories for visibility
 					if count < 50 { // limit logging to avoid spam
-						runtime.LogDebugf(a.ctx, "countprocessableitems: skipping excluded directory: %s", relPath)
+						a.logDebugf("countprocessableitems: skipping excluded directory: %s", relPath)
 					}
 				}
 				// if excluded, we've counted the directory itself but don't count its contents
 				// this dramatically reduces count for node_modules, .git, etc.
-			} else if !isExcluded {
-				// only count file content reads for non-excluded files
+			} else if !isExcluded && entry.follow && selection.treeMarker(relPath, false) == "" {
+				// only count file content reads for selected files
 				count++
 			}
 		}
 		return nil
 	}
 
-	err := counterHelper(rootDir, false)
+	err := counterHelper(root.Path, false)
 	if err != nil {
 		return 0, err // return error if counting was interrupted (e.g. context cancelled)
 	}
@@ -470,41 +467,94 @@ type generationProgressState struct {
 }
 
 func (a *App) emitProgress(state *generationProgressState) {
-	runtime.EventsEmit(a.ctx, "shotgunContextGenerationProgress", map[string]int{
+	a.emitEvent("shotgunContextGenerationProgress", map[string]int{
 		"current": state.processedItems,
-		"total":   state.totalItems,
+		"total":   max(state.totalItems, state.processedItems), // the total of a cached run is an estimate
 	})
 }
 
-// generateshotgunoutputwithprogress generates the txt output with progress reporting and size limits
-func (a *App) generateShotgunOutputWithProgress(jobCtx context.Context, rootDir string, excludedPaths []string) (string, error) {
+// generateshotgunoutputwithprogress streams the context to out with progress reporting and size limits.
+// the returned report is non-nil on success and carries the packing details when opts requested them.
+// out is not flushed; callers own the underlying file.
+// roots are the project folder or the roots of a workspace (see workspace.go).
+func (a *App) generateShotgunOutputWithProgress(jobCtx context.Context, roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions, out *contextWriter) (*ContextReport, error) {
 	if err := jobCtx.Err(); err != nil { // check for cancellation at the beginning
-		return "", err
+		return nil, err
+	}
+
+	formatter, err := newContextFormatter(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if err := validNotebookOutputs(opts.NotebookOutputs); err != nil {
+		return nil, err
 	}
 
-	excludedMap := make(map[string]bool)
-	for _, p := range excludedPaths {
-		excludedMap[p] = true
+	// a delta context needs the snapshot it is compared against
+	var base *ContextSnapshot
+	if opts.SinceSnapshot != "" {
+		if base, err = a.snapshots.get(opts.SinceSnapshot); err != nil {
+			return nil, err
+		}
+		if base.Workspace != workspaceKey(roots) {
+			return nil, fmt.Errorf("snapshot %s was taken of another project (%s)", base.ID, base.RootDir)
+		}
 	}
 
-	totalItems, err := a.countProcessableItems(jobCtx, rootDir, excludedMap)
+	selection, err := newContextSelection(jobCtx, roots, excludedPaths, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to count processable items: %w", err)
+		return nil, err
 	}
-	runtime.LogInfof(a.ctx, "context generation starting: %d items to process (excluded directories not traversed)", totalItems)
-	progressState := &generationProgressState{processedItems: 0, totalItems: totalItems}
-	a.emitProgress(progressState) // initial progress (0 / total)
 
-	var output strings.Builder
-	var fileContents strings.Builder
+	walkers := make([]*symlinkWalker, len(roots))
+	for i, root := range roots {
+		if walkers[i], err = a.rootWalker(root); err != nil {
+			return nil, err
+		}
+		var done func()
+		if walkers[i].tree, done, err = walkers[i].tree.forRun(jobCtx); err != nil {
+			return nil, err
+		}
+		defer done()
+	}
 
-	// root directory line
-	output.WriteString(filepath.Base(rootDir) + string(os.PathSeparator) + "\n")
-	progressState.processedItems++
-	a.emitProgress(progressState)
-	if output.Len() > maxOutputSizeBytes {
-		return "", fmt.Errorf("%w: content limit of %d bytes exceeded after root dir line (size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, output.Len())
+	report := &ContextReport{RootDir: roots[0].Path, Format: formatter.name(), IncludePatterns: opts.IncludePatterns}
+	if isWorkspace(roots) {
+		report.Roots = roots
 	}
+	if selection.changes != nil {
+		report.Git = newGitChangesReport(opts.BaseRef, selection.changes)
+	}
+	// a context that ends up too long still returns the report with the composition, so
+	// the caller can see what took up the space. files not written by then count unread.
+	composition := newCompositionBuilder()
+	tooLong := func(unread []contextFileEntry, err error) (*ContextReport, error) {
+		for _, f := range unread {
+			composition.addUnread(f)
+		}
+		report.Composition = composition.build()
+		return report, err
+	}
+
+	// after the first run the cache knows the item count, which saves a walk of the tree
+	runID, totalItems := a.contentCache.beginRun(workspaceKey(roots), opts)
+	if totalItems == 0 {
+		for i, root := range roots {
+			count, err := a.countProcessableItems(jobCtx, root, walkers[i], selection)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count processable items: %w", err)
+			}
+			totalItems += count
+		}
+	}
+	a.logInfof("context generation starting: %d items to process (excluded directories not traversed)", totalItems)
+	progressState := &generationProgressState{processedItems: 0, totalItems: totalItems}
+	a.emitProgress(progressState) // initial progress (0 / total)
+
+	var output strings.Builder // the tree; the formatted context is streamed to out
+	var files []contextFileEntry
+	var root WorkspaceRoot    // the root being walked
+	var walker *symlinkWalker // its walker
 
 	// buildshotguntreerecursive is a recursive helper for generating the tree string and file contents
 	// optimized to show all files (including excluded) in tree but only read non-excluded file contents
@@ -516,9 +566,9 @@ func (a *App) generateShotgunOutputWithProgress(jobCtx context.Context, rootDir
 		default:
 		}
 
-		entries, err := os.ReadDir(currentPath)
+		entries, err := walker.readDir(currentPath)
 		if err != nil {
-			runtime.LogWarningf(a.ctx, "buildshotguntreerecursive: error reading dir %s: %v", currentPath, err)
+			a.logWarningf("buildshotguntreerecursive: error reading dir %s: %v", currentPath, err)
 			// decide if this error should halt the entire process or just skip this directory
 			// for now, returning nil to skip, but log it. could also return the error.
 			return nil // or return err if this should stop everything
@@ -528,32 +578,27 @@ func (a *App) generateShotgunOutputWithProgress(jobCtx context.Context, rootDir
 		sort.SliceStable(entries, func(i, j int) bool {
 			entryI := entries[i]
 			entryJ := entries[j]
-			isDirI := entryI.IsDir()
-			isDirJ := entryJ.IsDir()
+			isDirI := entryI.isDir
+			isDirJ := entryJ.isDir
 			if isDirI && !isDirJ {
 				return true
 			}
 			if !isDirI && isDirJ {
 				return false
 			}
-			return strings.ToLower(entryI.Name()) < strings.ToLower(entryJ.Name())
+			return strings.ToLower(entryI.name) < strings.ToLower(entryJ.name)
 		})
 
 		// include all entries in the tree (even excluded ones) but mark them
 		for i, entry := range entries {
-			// skip directories that should never be shown
-			if entry.IsDir() && alwaysExcludedDirs[entry.Name()] {
-				continue
-			}
-			
 			select {
 			case <-pCtx.Done():
 				return pCtx.Err()
 			default:
 			}
 
-			path := filepath.Join(currentPath, entry.Name())
-			relPath, _ := filepath.Rel(rootDir, path)
+			path := entry.path
+			relPath := root.relPath(path)
 
 			isLast := i == len(entries)-1
 
@@ -565,141 +610,416 @@ func (a *App) generateShotgunOutputWithProgress(jobCtx context.Context, rootDir
 			}
 
 			// determine if this item is excluded (by parent or by itself)
-			isExcluded := parentExcluded || excludedMap[relPath]
-			
-			// mark excluded files in the tree
-			markerSuffix := ""
-			if isExcluded {
-				markerSuffix = " [excluded]"
+			isExcluded := parentExcluded || selection.excluded[relPath]
+
+			// mark excluded files and files left out by the include patterns or the changed
+			// files mode in the tree; inlined files show their git status when it is known
+			markerSuffix := treeMarkerExcluded
+			if !isExcluded {
+				markerSuffix = selection.treeMarker(relPath, entry.isDir)
+			}
+			treeSuffix := markerSuffix
+			if status := selection.gitStatus(relPath); markerSuffix == "" && status != "" {
+				treeSuffix = " [" + status + "]"
 			}
-			output.WriteString(prefix + branch + entry.Name() + markerSuffix + "\n")
+			output.WriteString(prefix + branch + entry.treeLabel() + treeSuffix + "\n")
 
 			progressState.processedItems++ // for tree entry
 			a.emitProgress(progressState)
 
-			if output.Len()+fileContents.Len() > maxOutputSizeBytes {
-				return fmt.Errorf("%w: content limit of %d bytes exceeded during tree generation (size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, output.Len()+fileContents.Len())
+			if output.Len() > maxOutputSizeBytes {
+				return fmt.Errorf("%w: content limit of %d bytes exceeded during tree generation (size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, output.Len())
 			}
 
-			if entry.IsDir() {
+			if entry.isDir {
 				// only recurse into non-excluded directories for performance
 				// excluded directories are shown in tree but their contents are not processed
-				if !isExcluded {
+				if !isExcluded && entry.follow {
 					err := buildShotgunTreeRecursive(pCtx, path, nextPrefix, isExcluded)
 					if err != nil {
 						if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 							return err
 						}
-						runtime.LogWarningf(a.ctx, "buildshotguntreerecursive: error processing subdirectory %s: %v", path, err)
+						a.logWarningf("buildshotguntreerecursive: error processing subdirectory %s: %v", path, err)
 					}
 				} else {
 					// log when skipping large directories for performance tracking
-					runtime.LogDebugf(a.ctx, "skipping excluded directory: %s", relPath)
+					a.logDebugf("skipping excluded directory: %s", relPath)
 				}
 				// if excluded, we've already shown it in the tree with [excluded] marker
 				// but we don't recurse into it - this saves massive processing for node_modules, .git, etc.
-			} else if !isExcluded {
-				// only include file contents if not excluded
-				select { // check before heavy i/o
-				case <-pCtx.Done():
-					return pCtx.Err()
-				default:
+			} else if markerSuffix == "" && entry.follow {
+				// file contents are read after the tree is complete so that the packing
+				// plan (token budget) can see every candidate first.
+				size, modTime := entry.stat()
+				files = append(files, contextFileEntry{relPath: relPath, absPath: path, rootDir: root.Path, size: size, modTime: modTime, gitStatus: selection.gitStatus(relPath), renamedFrom: selection.renamedFrom[relPath], tree: walker.tree})
+			}
+		}
+		return nil
+	}
+
+	// one tree per root, each starting with its root directory line
+	for i := range roots {
+		root, walker = roots[i], walkers[i]
+		// a root at a git ref names the ref and its commit after the directory
+		rootLine := root.treeLabel() + string(os.PathSeparator) + walker.tree.refLabel()
+		rootExcluded := selection.excluded[root.prefixed(".")] // a workspace root the user unchecked
+		if rootExcluded {
+			rootLine += treeMarkerExcluded
+		}
+		output.WriteString(rootLine + "\n")
+		progressState.processedItems++
+		a.emitProgress(progressState)
+		if output.Len() > maxOutputSizeBytes {
+			err = fmt.Errorf("%w: content limit of %d bytes exceeded after root dir line (size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, output.Len())
+			break
+		}
+		if rootExcluded {
+			continue
+		}
+		if err = buildShotgunTreeRecursive(jobCtx, root.Path, "", false); err != nil {
+			break
+		}
+	}
+	composition.c.TreeBytes = int64(output.Len())
+	composition.c.TreeLines = strings.Count(output.String(), "\n")
+	if err != nil {
+		err = fmt.Errorf("failed to build tree for shotgun: %w", err)
+		if errors.Is(err, ErrContextTooLong) {
+			composition.c.Incomplete = true
+			return tooLong(files, err)
+		}
+		return nil, err
+	}
+
+	// a delta context replaces the tree by a header naming the snapshot and the removed paths
+	var deltaHeader strings.Builder
+	if base != nil {
+		report.Delta = &DeltaReport{Since: base.ID, Added: []string{}, Modified: []string{}, Removed: removedSince(base, files), Omitted: []string{}}
+		if err := formatter.writeDelta(&deltaHeader, base.ID, report.Delta.Removed); err != nil {
+			return nil, err
+		}
+	}
+
+	var omit map[string]string
+	if opts.TokenBudget > 0 {
+		planned, fixedBytes := files, output.Len()+1
+		if base != nil {
+			// a delta has no tree, and the files unchanged on disk since the snapshot are
+			// left out of it, so neither takes up budget
+			fingerprint := snapshotFingerprint(opts)
+			planned, fixedBytes = nil, deltaHeader.Len()
+			for _, f := range files {
+				if !base.unchangedOnDisk(f, fingerprint) {
+					planned = append(planned, f)
 				}
-				// ensure forward slashes for the name attribute, consistent with documentation.
-				relPathForwardSlash := filepath.ToSlash(relPath)
+			}
+		}
+		omit, report.Packing, err = planTokenBudget(planned, fixedBytes, opts, formatter)
+		if err != nil {
+			return tooLong(files, err)
+		}
+		a.logInfof("token budget %d: inlining %d of %d files (~%d tokens)", opts.TokenBudget, report.Packing.IncludedFiles, len(planned), report.Packing.UsedTokens)
+	}
+
+	transformer, err := newContentTransformer(opts.Transforms)
+	if err != nil {
+		return nil, err
+	}
+	if transformer != nil {
+		report.Transforms = transformer.newReport()
+	}
 
-				// skip oversized files early to reduce memory churn
-				if fi, statErr := entry.Info(); statErr == nil && fi.Size() > maxFileReadSizeBytes {
-					fileContents.WriteString(fmt.Sprintf("<file path=\"%s\">\n", relPathForwardSlash))
-					fileContents.WriteString("[file omitted: too large]")
-					fileContents.WriteString("\n</file>\n")
+	var redactor *secretRedactor
+	if !opts.DisableRedaction {
+		redactor = newSecretRedactor()
+		report.Secrets = redactor.report
+	}
 
-					progressState.processedItems++ // for file content
-					a.emitProgress(progressState)
-					if output.Len()+fileContents.Len() > maxOutputSizeBytes {
-						return fmt.Errorf("%w: content limit of %d bytes exceeded after omitting large file %s (total size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, relPath, output.Len()+fileContents.Len())
+	outlines := newOutlineSelector(opts.OutlinePaths)
+	if outlines != nil {
+		report.Outlines = &OutlineReport{}
+	}
+
+	var duplicates *duplicateTracker
+	if !opts.DisableDedup {
+		duplicates = newDuplicateTracker()
+	}
+
+	snapshot := &ContextSnapshot{
+		Workspace: workspaceKey(roots), RootDir: roots[0].Path, Created: time.Now(), Files: make(map[string]string, len(files)),
+		Options: snapshotFingerprint(opts), Stats: make(map[string]string, len(files)),
+	}
+	if base != nil {
+		_, err = io.WriteString(out, deltaHeader.String())
+	} else {
+		err = formatter.writeTree(out, output.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	composition.c.TreeBytes, composition.c.TreeLines = out.size, out.lines // as formatted
+	// files written, or left out of a delta as unchanged. the contents are loaded on a
+	// bounded worker pool but written strictly in tree order.
+	written := 0
+	err = forEachOrdered(jobCtx, len(files), contextReadWorkers(), contextReadAheadFiles,
+		func(i int) loadedFile {
+			f := files[i]
+			slashPath := filepath.ToSlash(f.relPath)
+			omitReason := omit[f.relPath]
+			var loaded loadedFile
+			if omitReason != omitReasonBudget { // budget markers cost nothing to make
+				loaded, loaded.cached = a.contentCache.get(runID, f)
+			}
+			if !loaded.cached {
+				body := a.loadContextFile(f, omitReason, excerptLineCount(opts))
+				loaded = loadedFile{block: contextFileBlock{Content: body.text, Attrs: body.attrs}, excerpt: body.excerpt, inlined: body.inlined}
+				if body.inlined {
+					text := body.text
+					if isNotebookPath(f.relPath) && opts.NotebookOutputs != notebookOutputsRaw {
+						if flat, language, err := flattenNotebook(text, opts.NotebookOutputs); err == nil {
+							loaded.notebook, loaded.notebookSave = true, len(text)-len(flat)
+							loaded.block.Language = language
+							loaded.block.Attrs = append(loaded.block.Attrs, fileAttr{Name: "notebook", Value: "flattened"})
+							text = flat
+						} else {
+							a.logDebugf("notebook: %s is inlined as json, it does not parse: %v", f.relPath, err)
+						}
+					}
+					if outlines.matches(f.relPath) {
+						if outline, err := goOutline(text); err == nil {
+							loaded.outlined, loaded.outlineSave = true, len(text)-len(outline)
+							loaded.block.Attrs = append(loaded.block.Attrs, fileAttr{Name: "outline", Value: "true"})
+							text = outline
+						} else {
+							a.logDebugf("outline: %s is inlined in full, it does not parse: %v", f.relPath, err)
+						}
 					}
-					continue
+					loaded.block.Content, loaded.transforms = transformer.apply(slashPath, text)
+					// transforms only delete text: while no line break went, every line is
+					// still on the line of the file
+					loaded.fileLines = !loaded.notebook && !loaded.outlined &&
+						strings.Count(loaded.block.Content, "\n") == strings.Count(text, "\n")
+					loaded.hash = hashInlinedBody(loaded.block.Content)
 				}
-
-				content, err := os.ReadFile(path)
-				if err != nil {
-					runtime.LogWarningf(a.ctx, "buildshotguntreerecursive: error reading file %s: %v", path, err)
-					content = []byte(fmt.Sprintf("error reading file: %v", err))
+				if redactor != nil {
+					loaded.secrets = detectSecrets(slashPath, loaded.block.Content)
 				}
-
-				fileContents.WriteString(fmt.Sprintf("<file path=\"%s\">\n", relPathForwardSlash))
-				if isTextContent(content) {
-					fileContents.WriteString(string(content))
-				} else {
-					fileContents.WriteString("[non-text file content omitted]")
+				if omitReason != omitReasonBudget {
+					a.contentCache.put(runID, f, loaded)
+				}
+			}
+			if opts.IncludeDiffs && f.gitStatus != "" {
+				loaded.block.Diff = a.loadGitDiff(jobCtx, opts.BaseRef, f)
+				if redactor != nil {
+					loaded.diffSecrets = detectSecrets(slashPath, loaded.block.Diff)
+				}
+			}
+			return loaded
+		},
+		func(i int, loaded loadedFile) error {
+			f := files[i]
+			block := loaded.block
+			// ensure forward slashes for the name attribute, consistent with documentation.
+			block.Path = filepath.ToSlash(f.relPath)
+			if block.Language == "" { // a flattened notebook has the language of its kernel
+				block.Language = languageForPath(block.Path)
+			}
+			// a copy of a file written earlier only refers to it, unless it has its own diff
+			sameAs, duplicate := duplicates.check(loaded.hash, block.Path)
+			duplicate = duplicate && block.Diff == ""
+			if duplicate {
+				block = contextFileBlock{Path: block.Path, Language: block.Language, SameAs: sameAs}
+				loaded.secrets = nil // the body is not written
+			}
+			if f.gitStatus != "" {
+				block.Attrs = append(block.Attrs, fileAttr{Name: "git-status", Value: f.gitStatus})
+			}
+			if report.Transforms != nil && !duplicate {
+				report.Transforms.add(loaded.transforms)
+			}
+			if report.Outlines != nil && loaded.outlined && !duplicate {
+				report.Outlines.Files++
+				report.Outlines.BytesSaved += loaded.outlineSave
+			}
+			if loaded.notebook && !duplicate {
+				if report.Notebooks == nil {
+					report.Notebooks = &NotebookReport{}
+				}
+				report.Notebooks.Files++
+				report.Notebooks.BytesSaved += loaded.notebookSave
+			}
+			if loaded.excerpt != nil {
+				report.Excerpted = append(report.Excerpted, *loaded.excerpt)
+			}
+			if loaded.cached {
+				report.ReusedFiles++
+			}
+			var findings, diffFindings []SecretFinding
+			if redactor != nil {
+				block.Content, findings = redactor.redact(block.Content, loaded.secrets)
+				block.Diff, diffFindings = redactor.redact(block.Diff, loaded.diffSecrets)
+			}
+			// numbered after redaction so multi-line secrets are matched on the plain text;
+			// placeholders keep the line breaks of a secret, so the numbers match the file.
+			// a body whose lines moved (a flattened notebook, an outline, stripped comment
+			// lines) is not numbered: its numbers would not be the file's
+			if opts.LineNumbers && loaded.fileLines && !duplicate {
+				block.Content = numberLines(block.Content)
+				block.Attrs = append(block.Attrs, fileAttr{Name: "line-numbers", Value: "true"})
+			}
+			hash := blockHash(block)
+			snapshot.Files[block.Path] = hash
+			if stat := fileStat(f); stat != "" {
+				snapshot.Stats[block.Path] = stat
+			}
+			if report.Delta != nil {
+				previous, seen := base.Files[block.Path]
+				change := deltaChangeAdded
+				switch {
+				case omit[f.relPath] == omitReasonBudget:
+					// the model keeps the version it saw, and so does the snapshot
+					change = deltaChangeOmitted
+					delete(snapshot.Files, block.Path)
+					delete(snapshot.Stats, block.Path)
+					if seen {
+						snapshot.Files[block.Path] = previous
+						if stat := base.Stats[block.Path]; stat != "" {
+							snapshot.Stats[block.Path] = stat
+						}
+					}
+				case seen && previous == hash:
+					report.Delta.Unchanged++
+					written++
+					progressState.processedItems++
+					a.emitProgress(progressState)
+					return nil
+				case seen:
+					change = deltaChangeModified
 				}
-				fileContents.WriteString("\n</file>\n") // each file block ends with a newline
+				switch change {
+				case deltaChangeAdded:
+					report.Delta.Added = append(report.Delta.Added, block.Path)
+				case deltaChangeModified:
+					report.Delta.Modified = append(report.Delta.Modified, block.Path)
+				default:
+					report.Delta.Omitted = append(report.Delta.Omitted, block.Path)
+				}
+				block.Attrs = append(block.Attrs, fileAttr{Name: "change", Value: change})
+			}
+			if redactor != nil {
+				redactor.record(block.Path, findings)
+				redactor.record(block.Path+" (git diff)", diffFindings)
+			}
+			sizeBefore, linesBefore := out.size, out.lines
+			if err := formatter.writeFile(out, block); err != nil {
+				return err
+			}
+			written++
+			composition.addFile(f.relPath, out.size-sizeBefore, out.lines-linesBefore)
+			if duplicate {
+				composition.addDuplicate(len(loaded.block.Content))
+			}
+			if !loaded.inlined {
+				switch block.Content {
+				case omittedBudgetMarker, omittedTooLargeMarker, omittedUnavailableMarker:
+					composition.c.OmittedFiles++
+				case omittedNonTextMarker:
+					composition.c.BinaryFiles++
+				}
+			}
 
-				progressState.processedItems++ // for file content
-				a.emitProgress(progressState)
+			progressState.processedItems++ // for file content
+			a.emitProgress(progressState)
 
-				if output.Len()+fileContents.Len() > maxOutputSizeBytes { // final check after append
-					return fmt.Errorf("%w: content limit of %d bytes exceeded after appending file %s (total size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, relPath, output.Len()+fileContents.Len())
-				}
+			if out.size > maxOutputSizeBytes { // final check after append
+				return fmt.Errorf("%w: content limit of %d bytes exceeded after appending file %s (total size: %d bytes)", ErrContextTooLong, maxOutputSizeBytes, f.relPath, out.size)
 			}
-		}
-		return nil
+			return nil
+		})
+	if errors.Is(err, ErrContextTooLong) {
+		return tooLong(files[written:], err)
 	}
-
-	err = buildShotgunTreeRecursive(jobCtx, rootDir, "", false)
 	if err != nil {
-		return "", fmt.Errorf("failed to build tree for shotgun: %w", err)
+		return nil, err
 	}
 
 	if err := jobCtx.Err(); err != nil { // check for cancellation before final string operations
-		return "", err
+		return nil, err
 	}
-
-	// the final output is the tree, a newline, then all concatenated file contents.
-	// if filecontents is empty, we still want the newline after the tree.
-	// if filecontents is not empty, it already ends with a newline, so an extra one might not be desired
-	// depending on how it's structured. given each <file> block ends with \n, this should be fine.
-	return output.String() + "\n" + strings.TrimRight(fileContents.String(), "\n"), nil
+	if err := formatter.finish(out); err != nil {
+		return nil, err
+	}
+	report.Composition = composition.build()
+	report.snapshot = snapshot
+	if report.Packing != nil {
+		report.Packing.measure(out.size) // the plan only estimated from the sizes on disk
+	}
+	if report.Notebooks != nil {
+		report.Notebooks.TokensSaved = estimateTokens(report.Notebooks.BytesSaved)
+	}
+	if report.Outlines != nil {
+		report.Outlines.TokensSaved = estimateTokens(report.Outlines.BytesSaved)
+	}
+	a.contentCache.finishRun(runID, progressState.processedItems)
+	if a.contentCache != nil {
+		a.logInfof("context generation: reused %d of %d cached file blocks", report.ReusedFiles, len(files))
+	}
+	return report, nil
 }
 
 
 // --- watchman implementation ---
 
+// Watchman watches the project folder, or every root of a workspace with a watcher of
+// its own (see rootWatcher). changes in any root are reported with projectFilesChanged
+// and the path of the first root, which is the project root the frontend knows.
 type Watchman struct {
+	app *App
+
+	mu       sync.Mutex
+	roots    []WorkspaceRoot // what is watched; nil when stopped
+	watchers []*rootWatcher  // one per root
+}
+
+// rootWatcher watches one root with its own fsnotify watcher and ignore rules.
+type rootWatcher struct {
 	app         *App
 	rootDir     string
+	projectDir  string // reported with projectFilesChanged
 	fsWatcher   *fsnotify.Watcher
 	watchedDirs map[string]bool // tracks directories explicitly added to fsnotify
 
-	// lastknownstate map[string]filemeta // removed, fsnotify handles state
-	mu         sync.Mutex // changed to mutex for simplicity with start/stop/refresh
+	mu         sync.Mutex
 	cancelFunc context.CancelFunc
 
 	// store current patterns to be used by scandirectorystateinternal
-	currentProjectGitignore *gitignore.GitIgnore
+	currentProjectGitignore *gitIgnoreMatcher
 	currentCustomPatterns   *gitignore.GitIgnore
+	hiddenDirs              map[string]bool // hard-excluded directory names, never watched
 }
 
 func NewWatchman(app *App) *Watchman {
-	return &Watchman{
-		app:         app,
-		watchedDirs: make(map[string]bool),
-	}
+	return &Watchman{app: app}
 }
 
 // startfilewatcher is called by javascript to start watching a directory.
 func (a *App) StartFileWatcher(rootDirPath string) error {
-	runtime.LogInfof(a.ctx, "startfilewatcher called for: %s", rootDirPath)
+	a.logInfof("startfilewatcher called for: %s", rootDirPath)
 	if a.fileWatcher == nil {
 		return fmt.Errorf("file watcher not initialized")
 	}
-	return a.fileWatcher.Start(rootDirPath)
+	if rootDirPath == "" {
+		a.fileWatcher.Stop()
+		a.logInfo("watchman: root directory is empty, not starting.")
+		return nil
+	}
+	return a.fileWatcher.Start(projectRoots(rootDirPath))
 }
 
 // stopfilewatcher is called by javascript to stop the current watcher.
 func (a *App) StopFileWatcher() error {
-	runtime.LogInfo(a.ctx, "stopfilewatcher called")
+	a.logInfo("stopfilewatcher called")
 	if a.fileWatcher == nil {
 		return fmt.Errorf("file watcher not initialized")
 	}
@@ -707,132 +1027,181 @@ func (a *App) StopFileWatcher() error {
 	return nil
 }
 
-func (w *Watchman) Start(newRootDir string) error {
+// Start replaces the current watchers with one watcher per root.
+func (w *Watchman) Start(roots []WorkspaceRoot) error {
 	w.Stop() // stop any existing watcher
 
 	w.mu.Lock()
-	w.rootDir = newRootDir
-	if w.rootDir == "" {
-		w.mu.Unlock()
-		runtime.LogInfo(w.app.ctx, "watchman: root directory is empty, not starting.")
-		return nil
+	defer w.mu.Unlock()
+	w.roots = roots
+	for _, root := range roots {
+		if isArchiveRoot(root.Path) {
+			w.app.logDebugf("watchman: %s is an archive, it is not watched", root.Path)
+			continue
+		}
+		if root.Ref != "" {
+			w.app.logDebugf("watchman: %s is read at git ref %s, it is not watched", root.Path, root.Ref)
+			continue
+		}
+		rw := &rootWatcher{app: w.app, rootDir: root.Path, projectDir: roots[0].Path}
+		if err := rw.start(); err != nil {
+			w.stopLocked()
+			return err
+		}
+		w.watchers = append(w.watchers, rw)
+	}
+	return nil
+}
+
+func (w *Watchman) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopLocked()
+}
+
+func (w *Watchman) stopLocked() {
+	for _, rw := range w.watchers {
+		rw.stop()
 	}
+	w.watchers = nil
+	w.roots = nil
+}
+
+// isWatching reports whether a project or workspace is being watched.
+func (w *Watchman) isWatching() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.roots) > 0
+}
+
+// refreshignoresandrescan is called when ignore settings change in the app.
+func (w *Watchman) RefreshIgnoresAndRescan() error {
+	w.mu.Lock()
+	roots := w.roots
 	w.mu.Unlock()
+	if len(roots) == 0 {
+		w.app.logInfo("watchman.refreshignoresandrescan: no rootdir, skipping.")
+		return nil
+	}
+	w.app.logInfo("watchman.refreshignoresandrescan: refreshing ignore patterns and re-scanning.")
+
+	// restart the watchers so they pick up the patterns from the app's current state
+	if err := w.Start(roots); err != nil {
+		w.app.logErrorf("watchman.refreshignoresandrescan: error restarting watchers: %v", err)
+		return err
+	}
+	w.app.notifyFileChange(roots[0].Path) // notify frontend to refresh its view
+	return nil
+}
 
+// start creates the fsnotify watcher of the root and starts monitoring it.
+func (rw *rootWatcher) start() error {
 	// initialize patterns based on app's current state
-	if w.app.useGitignore {
-		w.currentProjectGitignore = w.app.projectGitignore
-	} else {
-		w.currentProjectGitignore = nil
+	if rw.app.useGitignore {
+		rw.currentProjectGitignore = rw.app.projectGitignores[rw.rootDir]
 	}
-	if w.app.useCustomIgnore {
-		w.currentCustomPatterns = w.app.currentCustomIgnorePatterns
-	} else {
-		w.currentCustomPatterns = nil
+	if rw.app.useCustomIgnore {
+		rw.currentCustomPatterns = rw.app.currentCustomIgnorePatterns
 	}
+	rw.hiddenDirs = rw.app.hardExcludedDirSet(rw.rootDir)
 
-	w.mu.Lock()
-	// ensure settings are loaded if they haven't been (e.g. if called before startup completes, though unlikely)
-	// however, loadsettings is called in startup, so this should generally be populated.
-	ctx, cancel := context.WithCancel(w.app.ctx) // use app's context as parent
-	w.cancelFunc = cancel
-	w.mu.Unlock()
+	ctx, cancel := context.WithCancel(rw.app.ctx) // use app's context as parent
+	rw.cancelFunc = cancel
 
 	var err error
-	w.fsWatcher, err = fsnotify.NewWatcher()
+	rw.fsWatcher, err = fsnotify.NewWatcher()
 	if err != nil {
-		runtime.LogErrorf(w.app.ctx, "watchman: error creating fsnotify watcher: %v", err)
+		cancel()
+		rw.app.logErrorf("watchman: error creating fsnotify watcher: %v", err)
 		return fmt.Errorf("failed to create fsnotify watcher: %w", err)
 	}
-	w.watchedDirs = make(map[string]bool) // initialize/clear
+	rw.watchedDirs = make(map[string]bool) // initialize/clear
 
-	runtime.LogInfof(w.app.ctx, "watchman: starting for directory %s", newRootDir)
-	w.addPathsToWatcherRecursive(newRootDir) // add initial paths
+	rw.app.logInfof("watchman: starting for directory %s", rw.rootDir)
+	rw.addPathsToWatcherRecursive(rw.rootDir) // add initial paths
 
-	go w.run(ctx)
+	go rw.run(ctx, rw.fsWatcher)
 	return nil
 }
 
-func (w *Watchman) Stop() {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+func (rw *rootWatcher) stop() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
 
-	if w.cancelFunc != nil {
-		runtime.LogInfo(w.app.ctx, "watchman: stopping...")
-		w.cancelFunc()
-		w.cancelFunc = nil // allow gc and prevent double-cancel
+	if rw.cancelFunc != nil {
+		rw.app.logInfof("watchman: stopping watcher for %s...", rw.rootDir)
+		rw.cancelFunc()
+		rw.cancelFunc = nil // allow gc and prevent double-cancel
 	}
-	if w.fsWatcher != nil {
-		err := w.fsWatcher.Close()
+	if rw.fsWatcher != nil {
+		err := rw.fsWatcher.Close()
 		if err != nil {
-			runtime.LogWarningf(w.app.ctx, "watchman: error closing fsnotify watcher: %v", err)
+			rw.app.logWarningf("watchman: error closing fsnotify watcher: %v", err)
 		}
-		w.fsWatcher = nil
+		rw.fsWatcher = nil
 	}
-	w.rootDir = ""
-	w.watchedDirs = make(map[string]bool) // clear watched directories
+	rw.watchedDirs = make(map[string]bool) // clear watched directories
 }
 
-func (w *Watchman) run(ctx context.Context) {
+func (rw *rootWatcher) run(ctx context.Context, fsW *fsnotify.Watcher) {
 	defer func() {
-		if w.fsWatcher != nil {
-			// this close is a safeguard; stop() should ideally be called.
-			w.fsWatcher.Close()
-		}
-		runtime.LogInfo(w.app.ctx, "watchman: goroutine stopped.")
+		// this close is a safeguard; stop() should ideally be called.
+		fsW.Close()
+		rw.app.logInfo("watchman: goroutine stopped.")
 	}()
 
-	w.mu.Lock()
-	currentRootDir := w.rootDir
-	w.mu.Unlock()
-	runtime.LogInfof(w.app.ctx, "watchman: monitoring goroutine started for %s", currentRootDir)
+	rw.app.logInfof("watchman: monitoring goroutine started for %s", rw.rootDir)
 
 	for {
 		select {
 		case <-ctx.Done():
-			w.mu.Lock()
-			shutdownRootDir := w.rootDir // re-fetch rootdir under lock as it might have changed
-			w.mu.Unlock()
-			runtime.LogInfof(w.app.ctx, "watchman: context cancelled, shutting down watcher for %s.", shutdownRootDir)
+			rw.app.logInfof("watchman: context cancelled, shutting down watcher for %s.", rw.rootDir)
 			return
 
-		case event, ok := <-w.fsWatcher.Events:
+		case event, ok := <-fsW.Events:
 			if !ok {
-				runtime.LogInfo(w.app.ctx, "watchman: fsnotify events channel closed.")
+				rw.app.logInfo("watchman: fsnotify events channel closed.")
 				return
 			}
-			runtime.LogDebugf(w.app.ctx, "watchman: fsnotify event: %s", event)
+			rw.app.logDebugf("watchman: fsnotify event: %s", event)
 
-			w.mu.Lock()
-			currentRootDir = w.rootDir // update currentrootdir under lock
+			rw.mu.Lock()
 			// safely copy ignore patterns
-			projIgn := w.currentProjectGitignore
-			custIgn := w.currentCustomPatterns
-			w.mu.Unlock()
+			projIgn := rw.currentProjectGitignore
+			custIgn := rw.currentCustomPatterns
+			isWatchedDir := rw.watchedDirs[event.Name]
+			rw.mu.Unlock()
 
-			if currentRootDir == "" { // watcher might have been stopped
+			relEventPath, err := filepath.Rel(rw.rootDir, event.Name)
+			if err != nil {
+				rw.app.logWarningf("watchman: could not get relative path for event %s (root: %s): %v", event.Name, rw.rootDir, err)
 				continue
 			}
 
-			relEventPath, err := filepath.Rel(currentRootDir, event.Name)
-			if err != nil {
-				runtime.LogWarningf(w.app.ctx, "watchman: could not get relative path for event %s (root: %s): %v", event.Name, currentRootDir, err)
-				continue
+			// an edited .gitignore changes which paths are ignored; drop the cached rules
+			if filepath.Base(event.Name) == ".gitignore" {
+				projIgn.invalidate()
 			}
 
-			// check if the event path is ignored
-			isIgnoredByGit := projIgn != nil && projIgn.MatchesPath(relEventPath)
+			// check if the event path is ignored. removed paths can no longer be stat'ed,
+			// so fall back to whether we were watching them as a directory.
+			isDir := isWatchedDir
+			if info, statErr := os.Stat(event.Name); statErr == nil {
+				isDir = info.IsDir()
+			}
+			isIgnoredByGit := projIgn != nil && projIgn.isIgnored(relEventPath, isDir)
 			isIgnoredByCustom := custIgn != nil && custIgn.MatchesPath(relEventPath)
 
-			if isIgnoredByGit || isIgnoredByCustom {
-				runtime.LogDebugf(w.app.ctx, "watchman: ignoring event for %s as it's an ignored path.", event.Name)
+			if isIgnoredByGit || isIgnoredByCustom || isHardExcludedPath(rw.hiddenDirs, relEventPath, isDir) {
+				rw.app.logDebugf("watchman: ignoring event for %s as it's an ignored path.", event.Name)
 				continue
 			}
 
 			// handle relevant events (excluding chmod)
 			if event.Op&fsnotify.Chmod == 0 {
-				runtime.LogInfof(w.app.ctx, "watchman: relevant change detected for %s in %s", event.Name, currentRootDir)
-				w.app.notifyFileChange(currentRootDir)
+				rw.app.logInfof("watchman: relevant change detected for %s in %s", event.Name, rw.rootDir)
+				rw.app.contentCache.invalidate(event.Name)
+				rw.app.notifyFileChange(rw.projectDir)
 			}
 
 			// dynamic directory watching
@@ -840,60 +1209,60 @@ func (w *Watchman) run(ctx context.Context) {
 				info, statErr := os.Stat(event.Name)
 				if statErr == nil && info.IsDir() {
 					// check if this new directory itself is ignored before adding
-					isNewDirIgnoredByGit := projIgn != nil && projIgn.MatchesPath(relEventPath)
+					isNewDirIgnoredByGit := projIgn != nil && projIgn.isIgnored(relEventPath, true)
 					isNewDirIgnoredByCustom := custIgn != nil && custIgn.MatchesPath(relEventPath)
 					if !isNewDirIgnoredByGit && !isNewDirIgnoredByCustom {
-						runtime.LogDebugf(w.app.ctx, "watchman: new directory created %s, adding to watcher.", event.Name)
-						w.addPathsToWatcherRecursive(event.Name) // this will add event.name and its children
+						rw.app.logDebugf("watchman: new directory created %s, adding to watcher.", event.Name)
+						rw.addPathsToWatcherRecursive(event.Name) // this will add event.name and its children
 					} else {
-						runtime.LogDebugf(w.app.ctx, "watchman: new directory %s is ignored, not adding to watcher.", event.Name)
+						rw.app.logDebugf("watchman: new directory %s is ignored, not adding to watcher.", event.Name)
 					}
 				}
 			}
 
 			if event.Op&fsnotify.Remove != 0 || event.Op&fsnotify.Rename != 0 {
-				w.mu.Lock()
-				if w.watchedDirs[event.Name] {
-					runtime.LogDebugf(w.app.ctx, "watchman: watched directory %s removed/renamed, removing from watcher.", event.Name)
+				rw.mu.Lock()
+				if rw.watchedDirs[event.Name] {
+					rw.app.logDebugf("watchman: watched directory %s removed/renamed, removing from watcher.", event.Name)
 					// fsnotify might remove it automatically, but explicit removal is safer for our tracking
-					if w.fsWatcher != nil { // check fswatcher as it might be closed by stop()
-						err := w.fsWatcher.Remove(event.Name)
+					if rw.fsWatcher != nil { // check fswatcher as it might be closed by stop()
+						err := rw.fsWatcher.Remove(event.Name)
 						if err != nil {
-							runtime.LogWarningf(w.app.ctx, "watchman: error removing path %s from fsnotify: %v", event.Name, err)
+							rw.app.logWarningf("watchman: error removing path %s from fsnotify: %v", event.Name, err)
 						}
 					}
-					delete(w.watchedDirs, event.Name)
+					delete(rw.watchedDirs, event.Name)
 				}
-				w.mu.Unlock()
+				rw.mu.Unlock()
 			}
 
-		case err, ok := <-w.fsWatcher.Errors:
+		case err, ok := <-fsW.Errors:
 			if !ok {
-				runtime.LogInfo(w.app.ctx, "watchman: fsnotify errors channel closed.")
+				rw.app.logInfo("watchman: fsnotify errors channel closed.")
 				return
 			}
-			runtime.LogErrorf(w.app.ctx, "watchman: fsnotify error: %v", err)
+			rw.app.logErrorf("watchman: fsnotify error: %v", err)
 		}
 	}
 }
 
-func (w *Watchman) addPathsToWatcherRecursive(baseDirToAdd string) {
-	w.mu.Lock() // lock to access watcher and ignore patterns
-	fsW := w.fsWatcher
-	projIgn := w.currentProjectGitignore
-	custIgn := w.currentCustomPatterns
-	overallRoot := w.rootDir
-	w.mu.Unlock()
+func (rw *rootWatcher) addPathsToWatcherRecursive(baseDirToAdd string) {
+	rw.mu.Lock() // lock to access watcher and ignore patterns
+	fsW := rw.fsWatcher
+	projIgn := rw.currentProjectGitignore
+	custIgn := rw.currentCustomPatterns
+	overallRoot := rw.rootDir
+	rw.mu.Unlock()
 
 	if fsW == nil || overallRoot == "" {
-		runtime.LogWarningf(w.app.ctx, "watchman.addpathstowatcherrecursive: fswatcher is nil or rootdir is empty. skipping add for %s.", baseDirToAdd)
+		rw.app.logWarningf("watchman.addpathstowatcherrecursive: fswatcher is nil or rootdir is empty. skipping add for %s.", baseDirToAdd)
 		return
 	}
 
 	errWalk := godirwalk.Walk(baseDirToAdd, &godirwalk.Options{
 		Unsorted: true,
 		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-			runtime.LogWarningf(w.app.ctx, "watchman scan error accessing %s: %v", osPathname, err)
+			rw.app.logWarningf("watchman scan error accessing %s: %v", osPathname, err)
 			return godirwalk.SkipNode
 		},
 		Callback: func(path string, de *godirwalk.Dirent) error {
@@ -903,94 +1272,45 @@ func (w *Watchman) addPathsToWatcherRecursive(baseDirToAdd string) {
 
 			relPath, errRel := filepath.Rel(overallRoot, path)
 			if errRel != nil {
-				runtime.LogWarningf(w.app.ctx, "watchman.addpathstowatcherrecursive: could not get relative path for %s (root: %s): %v", path, overallRoot, errRel)
+				rw.app.logWarningf("watchman.addpathstowatcherrecursive: could not get relative path for %s (root: %s): %v", path, overallRoot, errRel)
 				return nil
 			}
 
-			// skip .git directory at root level
-			if de.Name() == ".git" {
-				parentDir := filepath.Dir(path)
-				if parentDir == overallRoot {
-					runtime.LogDebugf(w.app.ctx, "watchman.addpathstowatcherrecursive: skipping .git directory: %s", path)
-					return godirwalk.SkipThis
-				}
+			// skip hard-excluded directories (.git and the like) at any depth
+			if path != overallRoot && rw.hiddenDirs[de.Name()] {
+				rw.app.logDebugf("watchman.addpathstowatcherrecursive: skipping hard-excluded directory: %s", path)
+				return godirwalk.SkipThis
 			}
 
-			isIgnoredByGit := projIgn != nil && projIgn.MatchesPath(relPath)
+			// the walk skips ignored directories, so matching the directory itself is enough
+			isIgnoredByGit := projIgn != nil && projIgn.match(relPath, true)
 			isIgnoredByCustom := custIgn != nil && custIgn.MatchesPath(relPath)
 
 			if isIgnoredByGit || isIgnoredByCustom {
-				runtime.LogDebugf(w.app.ctx, "watchman.addpathstowatcherrecursive: skipping ignored directory: %s", path)
+				rw.app.logDebugf("watchman.addpathstowatcherrecursive: skipping ignored directory: %s", path)
 				return godirwalk.SkipThis
 			}
 
 			errAdd := fsW.Add(path)
 			if errAdd != nil {
-				runtime.LogWarningf(w.app.ctx, "watchman.addpathstowatcherrecursive: error adding path %s to fsnotify: %v", path, errAdd)
+				rw.app.logWarningf("watchman.addpathstowatcherrecursive: error adding path %s to fsnotify: %v", path, errAdd)
 			} else {
-				runtime.LogDebugf(w.app.ctx, "watchman.addpathstowatcherrecursive: added to watcher: %s", path)
-				w.mu.Lock()
-				w.watchedDirs[path] = true
-				w.mu.Unlock()
+				rw.app.logDebugf("watchman.addpathstowatcherrecursive: added to watcher: %s", path)
+				rw.mu.Lock()
+				rw.watchedDirs[path] = true
+				rw.mu.Unlock()
 			}
 			return nil
 		},
 	})
 	if errWalk != nil {
-		runtime.LogWarningf(w.app.ctx, "watchman.addpathstowatcherrecursive: walk error: %v", errWalk)
+		rw.app.logWarningf("watchman.addpathstowatcherrecursive: walk error: %v", errWalk)
 	}
 }
 
 // notifyfilechange is an internal method for the app to emit a wails event.
 func (a *App) notifyFileChange(rootDir string) {
-	runtime.EventsEmit(a.ctx, "projectFilesChanged", rootDir)
-}
-
-// refreshignoresandrescan is called when ignore settings change in the app.
-func (w *Watchman) RefreshIgnoresAndRescan() error {
-	w.mu.Lock()
-	if w.rootDir == "" {
-		w.mu.Unlock()
-		runtime.LogInfo(w.app.ctx, "watchman.refreshignoresandrescan: no rootdir, skipping.")
-		return nil
-	}
-	runtime.LogInfo(w.app.ctx, "watchman.refreshignoresandrescan: refreshing ignore patterns and re-scanning.")
-
-	// update patterns based on app's current state
-	if w.app.useGitignore {
-		w.currentProjectGitignore = w.app.projectGitignore
-	} else {
-		w.currentProjectGitignore = nil
-	}
-	if w.app.useCustomIgnore {
-		w.currentCustomPatterns = w.app.currentCustomIgnorePatterns
-	} else {
-		w.currentCustomPatterns = nil
-	}
-	currentRootDir := w.rootDir
-	defer w.mu.Unlock()
-
-	// stop existing watcher (closes, clears watcheddirs)
-	if w.cancelFunc != nil {
-		w.cancelFunc()
-	}
-	if w.fsWatcher != nil {
-		w.fsWatcher.Close()
-	}
-	w.watchedDirs = make(map[string]bool)
-
-	// create new watcher
-	var err error
-	w.fsWatcher, err = fsnotify.NewWatcher()
-	if err != nil {
-		runtime.LogErrorf(w.app.ctx, "watchman.refreshignoresandrescan: error creating new fsnotify watcher: %v", err)
-		return fmt.Errorf("failed to create new fsnotify watcher: %w", err)
-	}
-
-	w.addPathsToWatcherRecursive(currentRootDir) // add paths with new rules
-	w.app.notifyFileChange(currentRootDir)       // notify frontend to refresh its view
-
-	return nil
+	a.emitEvent("projectFilesChanged", rootDir)
 }
 
 // --- configuration management ---
@@ -998,7 +1318,7 @@ func (w *Watchman) RefreshIgnoresAndRescan() error {
 func (a *App) compileCustomIgnorePatterns() error {
 	if strings.TrimSpace(a.settings.CustomIgnoreRules) == "" {
 		a.currentCustomIgnorePatterns = nil
-		runtime.LogDebug(a.ctx, "custom ignore rules are empty, no patterns compiled.")
+		a.logDebug("custom ignore rules are empty, no patterns compiled.")
 		return nil
 	}
 	lines := strings.Split(strings.ReplaceAll(a.settings.CustomIgnoreRules, "\r\n", "\n"), "\n")
@@ -1013,7 +1333,7 @@ func (a *App) compileCustomIgnorePatterns() error {
 		validLines = append(validLines, line)
 	}
 
-	runtime.LogInfof(a.ctx, "compiling %d lines with %d non-comment patterns", len(validLines), patternCount)
+	a.logInfof("compiling %d lines with %d non-comment patterns", len(validLines), patternCount)
 	
 	ign := gitignore.CompileIgnoreLines(validLines...)
 	// поскольку compileignorelines в этой версии не возвращает ошибку,
@@ -1023,9 +1343,9 @@ func (a *App) compileCustomIgnorePatterns() error {
 	a.currentCustomIgnorePatterns = ign
 	
 	if ign != nil {
-		runtime.LogInfof(a.ctx, "successfully compiled custom ignore patterns (%d active patterns)", patternCount)
+		a.logInfof("successfully compiled custom ignore patterns (%d active patterns)", patternCount)
 	} else {
-		runtime.LogWarning(a.ctx, "compiled patterns resulted in nil - all patterns may be comments or invalid")
+		a.logWarning("compiled patterns resulted in nil - all patterns may be comments or invalid")
 	}
 	
 	return nil
@@ -1036,7 +1356,7 @@ func (a *App) loadSettings() {
 	a.settings.CustomIgnoreRules = defaultCustomIgnoreRulesContent
 
 	if a.configPath == "" {
-		runtime.LogWarningf(a.ctx, "config path is empty, using default custom ignore rules (embedded).")
+		a.logWarningf("config path is empty, using default custom ignore rules (embedded).")
 		if err := a.compileCustomIgnorePatterns(); err != nil {
 			// error already logged in compilecustomignorepatterns
 		}
@@ -1046,22 +1366,22 @@ func (a *App) loadSettings() {
 	data, err := os.ReadFile(a.configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			runtime.LogInfo(a.ctx, "settings file not found. using default custom ignore rules (embedded) and attempting to save them.")
+			a.logInfo("settings file not found. using default custom ignore rules (embedded) and attempting to save them.")
 			// save default settings to create the file. compilecustomignorepatterns will be called after this.
 			if errSave := a.saveSettings(); errSave != nil { // savesettings will use a.settings.customignorerules which is currently default
-				runtime.LogErrorf(a.ctx, "failed to save default settings: %v", errSave)
+				a.logErrorf("failed to save default settings: %v", errSave)
 			}
 		} else {
-			runtime.LogErrorf(a.ctx, "error reading settings file %s: %v. using default custom ignore rules (embedded).", a.configPath, err)
+			a.logErrorf("error reading settings file %s: %v. using default custom ignore rules (embedded).", a.configPath, err)
 		}
 	} else {
 		var loadedSettings AppSettings
 		err = json.Unmarshal(data, &loadedSettings)
 		if err != nil {
-			runtime.LogErrorf(a.ctx, "error unmarshalling settings from %s: %v. using default custom ignore rules (embedded).", a.configPath, err)
+			a.logErrorf("error unmarshalling settings from %s: %v. using default custom ignore rules (embedded).", a.configPath, err)
 			a.settings.CustomIgnoreRules = defaultCustomIgnoreRulesContent // reset to default on unmarshal error
 		} else {
-			runtime.LogInfo(a.ctx, "successfully loaded custom rules from config.")
+			a.logInfo("successfully loaded custom rules from config.")
 			// check if the loaded rules already contain the base rules (from a previous save)
 			// to avoid infinite appending
 			baseRules := defaultCustomIgnoreRulesContent
@@ -1077,6 +1397,10 @@ func (a *App) loadSettings() {
 				a.settings.CustomIgnoreRules = baseRules + "\n\n#--- user rules ---\n" + userRules
 			}
 
+			a.settings.SymlinkPolicy = loadedSettings.SymlinkPolicy
+			a.settings.HardExcludedDirs = loadedSettings.HardExcludedDirs
+			a.settings.ProjectHardExcludedDirs = loadedSettings.ProjectHardExcludedDirs
+
 			// handle custompromptrules separately as it's a replacement, not an addition.
 			if strings.TrimSpace(loadedSettings.CustomPromptRules) != "" {
 				a.settings.CustomPromptRules = loadedSettings.CustomPromptRules
@@ -1094,7 +1418,7 @@ func (a *App) loadSettings() {
 func (a *App) saveSettings() error {
 	if a.configPath == "" {
 		err := errors.New("config path is not set, cannot save settings")
-		runtime.LogError(a.ctx, err.Error())
+		a.logError(err.Error())
 		return err
 	}
 
@@ -1102,7 +1426,11 @@ func (a *App) saveSettings() error {
 	settingsToSave := AppSettings{
 		CustomPromptRules: a.settings.CustomPromptRules,
 		GeminiAPIKey:      a.settings.GeminiAPIKey,
+		SymlinkPolicy:     a.settings.SymlinkPolicy,
 		CustomIgnoreRules: a.settings.CustomIgnoreRules, // default to full rules
+
+		HardExcludedDirs:        a.settings.HardExcludedDirs,
+		ProjectHardExcludedDirs: a.settings.ProjectHardExcludedDirs,
 	}
 	
 	// extract only user rules for saving (everything after "#--- user rules ---")
@@ -1116,22 +1444,22 @@ func (a *App) saveSettings() error {
 
 	data, err := json.MarshalIndent(settingsToSave, "", "  ")
 	if err != nil {
-		runtime.LogErrorf(a.ctx, "error marshalling settings: %v", err)
+		a.logErrorf("error marshalling settings: %v", err)
 		return err
 	}
 
 	configDir := filepath.Dir(a.configPath)
 	if err := os.MkdirAll(configDir, os.ModePerm); err != nil {
-		runtime.LogErrorf(a.ctx, "error creating config directory %s: %v", configDir, err)
+		a.logErrorf("error creating config directory %s: %v", configDir, err)
 		return err
 	}
 
 	err = os.WriteFile(a.configPath, data, 0644)
 	if err != nil {
-		runtime.LogErrorf(a.ctx, "error writing settings to %s: %v", a.configPath, err)
+		a.logErrorf("error writing settings to %s: %v", a.configPath, err)
 		return err
 	}
-	runtime.LogInfo(a.ctx, "settings saved successfully.")
+	a.logInfo("settings saved successfully.")
 	return nil
 }
 
@@ -1144,7 +1472,7 @@ func (a *App) GetCustomIgnoreRules() string {
 
 // setcustomignorerules updates the custom ignore rules, saves them, and recompiles.
 func (a *App) SetCustomIgnoreRules(rules string) error {
-	runtime.LogInfof(a.ctx, "setcustomignorerules: updating rules (length: %d)", len(rules))
+	a.logInfof("setcustomignorerules: updating rules (length: %d)", len(rules))
 	
 	// keep the full rules in memory for immediate use
 	a.settings.CustomIgnoreRules = rules
@@ -1152,7 +1480,7 @@ func (a *App) SetCustomIgnoreRules(rules string) error {
 	// compile the patterns first - this must succeed before we proceed
 	compileErr := a.compileCustomIgnorePatterns()
 	if compileErr != nil {
-		runtime.LogErrorf(a.ctx, "failed to compile custom ignore patterns: %v", compileErr)
+		a.logErrorf("failed to compile custom ignore patterns: %v", compileErr)
 		// restore previous patterns if compilation fails
 		return fmt.Errorf("failed to compile custom ignore patterns: %w", compileErr)
 	}
@@ -1160,21 +1488,21 @@ func (a *App) SetCustomIgnoreRules(rules string) error {
 	// save the settings after successful compilation
 	saveErr := a.saveSettings()
 	if saveErr != nil {
-		runtime.LogErrorf(a.ctx, "failed to save settings: %v", saveErr)
+		a.logErrorf("failed to save settings: %v", saveErr)
 		// patterns are compiled but not saved - they'll work until app restart
 		// continue with refresh despite save error
 	}
 	
 	// log the current state
-	runtime.LogInfof(a.ctx, "custom ignore patterns compiled successfully, currentCustomIgnorePatterns is now %v", 
+	a.logInfof("custom ignore patterns compiled successfully, currentCustomIgnorePatterns is now %v", 
 		a.currentCustomIgnorePatterns != nil)
 	
 	// refresh the file watcher if active - this will trigger a file tree reload
-	if a.fileWatcher != nil && a.fileWatcher.rootDir != "" {
-		runtime.LogInfo(a.ctx, "refreshing file watcher with new ignore patterns")
+	if a.fileWatcher != nil && a.fileWatcher.isWatching() {
+		a.logInfo("refreshing file watcher with new ignore patterns")
 		refreshErr := a.fileWatcher.RefreshIgnoresAndRescan()
 		if refreshErr != nil {
-			runtime.LogErrorf(a.ctx, "failed to refresh file watcher: %v", refreshErr)
+			a.logErrorf("failed to refresh file watcher: %v", refreshErr)
 		}
 		// even if refresh fails, patterns are updated and will be used by ListFiles
 	}
@@ -1183,7 +1511,7 @@ func (a *App) SetCustomIgnoreRules(rules string) error {
 		return fmt.Errorf("patterns updated but failed to save settings: %w", saveErr)
 	}
 	
-	runtime.LogInfo(a.ctx, "custom ignore rules successfully updated and applied")
+	a.logInfo("custom ignore rules successfully updated and applied")
 	return nil
 }
 
@@ -1202,15 +1530,15 @@ func (a *App) SetCustomPromptRules(rules string) error {
 	if err != nil {
 		return fmt.Errorf("failed to save custom prompt rules: %w", err)
 	}
-	runtime.LogInfo(a.ctx, "custom prompt rules saved successfully.")
+	a.logInfo("custom prompt rules saved successfully.")
 	return nil
 }
 
 // setusegitignore updates the app's setting for using .gitignore and informs the watcher.
 func (a *App) SetUseGitignore(enabled bool) error {
 	a.useGitignore = enabled
-	runtime.LogInfof(a.ctx, "app setting usegitignore changed to: %v", enabled)
-	if a.fileWatcher != nil && a.fileWatcher.rootDir != "" {
+	a.logInfof("app setting usegitignore changed to: %v", enabled)
+	if a.fileWatcher != nil && a.fileWatcher.isWatching() {
 		// assuming watcher is for the current project if active.
 		return a.fileWatcher.RefreshIgnoresAndRescan()
 	}
@@ -1220,8 +1548,8 @@ func (a *App) SetUseGitignore(enabled bool) error {
 // setusecustomignore updates the app's setting for using custom ignore rules and informs the watcher.
 func (a *App) SetUseCustomIgnore(enabled bool) error {
 	a.useCustomIgnore = enabled
-	runtime.LogInfof(a.ctx, "app setting usecustomignore changed to: %v", enabled)
-	if a.fileWatcher != nil && a.fileWatcher.rootDir != "" {
+	a.logInfof("app setting usecustomignore changed to: %v", enabled)
+	if a.fileWatcher != nil && a.fileWatcher.isWatching() {
 		// assuming watcher is for the current project if active.
 		return a.fileWatcher.RefreshIgnoresAndRescan()
 	}
@@ -1253,12 +1581,17 @@ func (a *App) getAPIKey() string {
 	return os.Getenv("GOOGLE_API_KEY")
 }
 
-// countgeminitokens counts the tokens in the provided text using Google's Gemini API
-func (a *App) CountGeminiTokens(text string) (int, error) {
+// countgeminitokens counts the tokens in the provided text using Google's Gemini API,
+// with the context of contextHandle in place of its placeholder (see context_store.go)
+func (a *App) CountGeminiTokens(text string, contextHandle string) (int, error) {
 	apiKey := a.getAPIKey()
 	if apiKey == "" {
 		return 0, fmt.Errorf("api key not set. please set GEMINI_API_KEY or GOOGLE_API_KEY environment variable, or configure it in settings")
 	}
+	text, err := a.expandPrompt(text, contextHandle)
+	if err != nil {
+		return 0, err
+	}
 
 	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
 	if err != nil {
@@ -1276,12 +1609,17 @@ func (a *App) CountGeminiTokens(text string) (int, error) {
 	return int(resp.TotalTokens), nil
 }
 
-// executegeminirequest sends a prompt to Google Gemini API
-func (a *App) ExecuteGeminiRequest(prompt string, modelName string) (string, error) {
+// executegeminirequest sends a prompt to Google Gemini API, with the context of
+// contextHandle in place of its placeholder
+func (a *App) ExecuteGeminiRequest(prompt string, modelName string, contextHandle string) (string, error) {
 	apiKey := a.getAPIKey()
 	if apiKey == "" {
 		return "", errors.New("api key not set. please set GEMINI_API_KEY or GOOGLE_API_KEY environment variable, or configure it in settings")
 	}
+	prompt, err := a.expandPrompt(prompt, contextHandle)
+	if err != nil {
+		return "", err
+	}
 
 	// create a context with cancellation capability
 	ctx, cancel := context.WithCancel(a.ctx)
@@ -1311,20 +1649,20 @@ func (a *App) ExecuteGeminiRequest(prompt string, modelName string) (string, err
 		bodyPreview = bodyPreview[:500] + "..."
 	}
 	charCount := utf8.RuneCountInString(prompt)
-	runtime.LogInfof(a.ctx, "gemini request config: model=%s temperature=0.1", modelName)
-	runtime.LogInfof(a.ctx, "gemini request body length: %d characters", charCount)
-	runtime.LogInfof(a.ctx, "gemini request body preview: %s", bodyPreview)
+	a.logInfof("gemini request config: model=%s temperature=0.1", modelName)
+	a.logInfof("gemini request body length: %d characters", charCount)
+	a.logInfof("gemini request body preview: %s", bodyPreview)
 
 	// create chat session
 	cs := model.StartChat()
 
-	runtime.EventsEmit(a.ctx, "gemini_request_start", nil)
+	a.emitEvent("gemini_request_start", nil)
 
 	// send the prompt and get response
 	resp, err := cs.SendMessage(ctx, genai.Text(prompt))
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
-			runtime.EventsEmit(a.ctx, "gemini_request_canceled", nil)
+			a.emitEvent("gemini_request_canceled", nil)
 			return "", errors.New("request was canceled")
 		}
 		return "", fmt.Errorf("failed to send message: %v", err)
@@ -1354,7 +1692,7 @@ func (a *App) ExecuteGeminiRequest(prompt string, modelName string) (string, err
 		return "", errors.New("no response from gemini")
 	}
 
-	runtime.EventsEmit(a.ctx, "gemini_request_complete", nil)
+	a.emitEvent("gemini_request_complete", nil)
 
 	return respText, nil
 }
@@ -1364,7 +1702,7 @@ func (a *App) StopGeminiRequest() error {
 	if a.geminiRequestCancel != nil {
 		a.geminiRequestCancel()
 		a.geminiRequestCancel = nil
-		runtime.EventsEmit(a.ctx, "gemini_request_canceled", nil)
+		a.emitEvent("gemini_request_canceled", nil)
 		return nil
 	}
 	return errors.New("no active gemini request to cancel")
@@ -1403,13 +1741,13 @@ func isTextContent(data []byte) bool {
 
 // resetapplication stops all running processes and resets application state
 func (a *App) ResetApplication() error {
-	runtime.LogInfo(a.ctx, "resetting application state...")
+	a.logInfo("resetting application state...")
 
 	// stop the gemini request if it's running
 	if a.geminiRequestCancel != nil {
 		a.geminiRequestCancel()
 		a.geminiRequestCancel = nil
-		runtime.LogInfo(a.ctx, "stopped active gemini request")
+		a.logInfo("stopped active gemini request")
 	}
 
 	// stop any context generation in progress
@@ -1418,22 +1756,22 @@ func (a *App) ResetApplication() error {
 		if a.contextGenerator.currentCancelFunc != nil {
 			a.contextGenerator.currentCancelFunc()
 			a.contextGenerator.currentCancelFunc = nil
-			runtime.LogInfo(a.ctx, "stopped active context generation")
+			a.logInfo("stopped active context generation")
 		}
 		a.contextGenerator.mu.Unlock()
 	}
 
 	// stop any active file watcher
 	if err := a.StopFileWatcher(); err != nil {
-		runtime.LogWarningf(a.ctx, "error stopping file watcher during reset: %v", err)
+		a.logWarningf("error stopping file watcher during reset: %v", err)
 	} else {
-		runtime.LogInfo(a.ctx, "stopped active file watcher")
+		a.logInfo("stopped active file watcher")
 	}
 
 	// reset window title
 	runtime.WindowSetTitle(a.ctx, "shotgun")
 
-	runtime.LogInfo(a.ctx, "application state reset complete")
+	a.logInfo("application state reset complete")
 	// clear defaultRootDir so frontend reload does not auto-reopen the previous folder
 	a.defaultRootDir = ""
 	return nil
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// --- archives as roots ---
+
+// a .zip, .tar, .tar.gz (.tgz) or .tar.bz2 (.tbz2) file can be opened like a project
+// folder: ListFiles, the workspaces, the cli and context generation take its path and
+// walk its entries as a virtual tree (see virtualtree.go). ignore rules, hard
+// exclusions, include patterns, text detection and the size limits apply as for a
+// folder; nothing is extracted to disk.
+//
+// a zip archive stays open while its tree is in use (the file is closed once the tree
+// is garbage collected) and every entry is inflated from the file when it is read. a
+// tar archive can only be read front to back, so the content of every entry up to
+// maxFileReadSizeBytes is kept in memory, at most maxTarBufferedBytes in all. larger
+// entries are listed with their size and replaced by the too-large marker; entries
+// below hard-excluded directories and those past the total are listed without their
+// content. archives are not watched and have no git status; an opened archive stays
+// cached until its size, its mtime or the hard exclusions change.
+
+// maxTarBufferedBytes bounds the entry contents kept in memory for one tar archive.
+const maxTarBufferedBytes = 64 * maxFileReadSizeBytes
+
+// archiveExtensions are the supported archive suffixes, longest first.
+var archiveExtensions = []string{".tar.gz", ".tar.bz2", ".tgz", ".tbz2", ".tar", ".zip"}
+
+// isArchivePath reports whether path names a supported archive.
+func isArchivePath(path string) bool {
+	return archiveExtension(path) != ""
+}
+
+func archiveExtension(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// openArchive reads the entries of the archive at path into a virtual tree rooted at
+// path. hiddenDirs are the names of the hard-excluded directories, whose tar entries
+// are not kept in memory.
+func openArchive(path string, hiddenDirs map[string]bool) (*virtualTree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := newVirtualTree(path)
+	keepOpen := false
+	switch ext := archiveExtension(path); ext {
+	case ".zip":
+		err = readZipArchive(tree, f)
+		keepOpen = err == nil // the entries are read from the file
+	case ".tar":
+		err = readTarArchive(tree, f, hiddenDirs)
+	case ".tar.gz", ".tgz":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(f); err == nil {
+			err = readTarArchive(tree, gz, hiddenDirs)
+		}
+	case ".tar.bz2", ".tbz2":
+		err = readTarArchive(tree, bzip2.NewReader(f), hiddenDirs)
+	default:
+		err = errors.New("not a supported archive")
+	}
+	if !keepOpen {
+		f.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+	return tree, nil
+}
+
+func readZipArchive(tree *virtualTree, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		p, ok := cleanVirtualPath(zf.Name)
+		if !ok {
+			continue
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			tree.addDir(p)
+		case mode&os.ModeSymlink != 0:
+			target, err := readZipLink(zf)
+			if err != nil {
+				return err
+			}
+			tree.addFile(p, &virtualFile{modTime: zf.Modified, link: target})
+		case mode.IsRegular():
+			tree.addFile(p, &virtualFile{
+				size:    int64(zf.UncompressedSize64),
+				modTime: zf.Modified,
+				open:    func() (io.ReadCloser, error) { return zf.Open() },
+			})
+		}
+	}
+	return nil
+}
+
+// readZipLink returns the target of a symbolic link, which zip stores as its content.
+func readZipLink(zf *zip.File) (string, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	target, err := io.ReadAll(io.LimitReader(r, 4096))
+	return string(target), err
+}
+
+func readTarArchive(tree *virtualTree, r io.Reader, hiddenDirs map[string]bool) error {
+	tr := tar.NewReader(r)
+	var buffered int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, ok := cleanVirtualPath(hdr.Name)
+		if !ok {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			tree.addDir(p)
+		case tar.TypeSymlink:
+			tree.addFile(p, &virtualFile{modTime: hdr.ModTime, link: hdr.Linkname})
+		case tar.TypeLink: // a hard link shares the content of an earlier entry
+			if target, ok := cleanVirtualPath(hdr.Linkname); ok && tree.files[target] != nil {
+				tree.addFile(p, tree.files[target])
+			}
+		case tar.TypeReg:
+			f := &virtualFile{size: hdr.Size, modTime: hdr.ModTime}
+			if hdr.Size <= maxFileReadSizeBytes && buffered+hdr.Size <= maxTarBufferedBytes && !inHiddenDir(p, hiddenDirs) {
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					return err
+				}
+				buffered += int64(len(data))
+				f.open = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+			}
+			tree.addFile(p, f)
+		}
+	}
+}
+
+// inHiddenDir reports whether a directory above the slash path p is one of hiddenDirs.
+func inHiddenDir(p string, hiddenDirs map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if hiddenDirs[path.Base(dir)] {
+			return true
+		}
+	}
+	return false
+}
+
+// isArchiveRoot reports whether the root at rootPath is an archive rather than a folder
+// (which may well be named like one).
+func isArchiveRoot(rootPath string) bool {
+	if !isArchivePath(rootPath) {
+		return false
+	}
+	info, err := os.Stat(rootPath)
+	return err == nil && !info.IsDir()
+}
+
+// openArchiveTree returns the tree of the archive at path, from the cache while the
+// file keeps its size and mtime and the hard exclusions stay the same.
+func (a *App) openArchiveTree(archivePath string) (*virtualTree, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	hiddenDirs := a.hardExcludedDirSet(archivePath)
+	names := make([]string, 0, len(hiddenDirs))
+	for name := range hiddenDirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stamp := fmt.Sprintf("%d %d %q", info.Size(), info.ModTime().UnixNano(), names)
+	return a.virtualTrees.get(archivePath, stamp, func() (*virtualTree, error) { return openArchive(archivePath, hiddenDirs) })
+}
+
+// SelectArchive opens a file dialog for an archive to open as a project. it returns ""
+// when the dialog is cancelled.
+func (a *App) SelectArchive() (string, error) {
+	archivePath, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:   "open a zip or tar archive",
+		Filters: []runtime.FileFilter{{DisplayName: "archives", Pattern: "*.zip;*.tar;*.tar.gz;*.tgz;*.tar.bz2;*.tbz2"}},
+	})
+	if err != nil && archivePath == "" {
+		a.logDebugf("selectarchive: dialog closed without selection: %v", err)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if archivePath != "" {
+		runtime.WindowSetTitle(a.ctx, fmt.Sprintf("%s - shotgun", filepath.Base(archivePath)))
+	}
+	return archivePath, nil
+}
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- headless command line mode ---
+
+// exit codes used by the command line mode. they are stable so scripts and
+// pre-commit hooks can rely on them.
+const (
+	exitOK             = 0   // success (check-ignore: at least one path is ignored)
+	exitFailure        = 1   // generic failure (check-ignore: no path is ignored)
+	exitUsage          = 2   // invalid arguments
+	exitContextTooLong = 3   // generated context exceeds maxoutputsizebytes
+	exitInterrupted    = 130 // cancelled by sigint/sigterm
+)
+
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) int
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	// assigned in init to break the initialization cycle through runHelpCommand.
+	cliCommands = []cliCommand{
+		{"context", "generate the project context without opening the window", runContextCommand},
+		{"ls", "print the file tree with ignore markers", runListCommand},
+		{"check-ignore", "report which paths are excluded by ignore rules", runCheckIgnoreCommand},
+		{"split-diff", "split a git diff into smaller diffs", runSplitDiffCommand},
+		{"help", "show this help", runHelpCommand},
+	}
+}
+
+// iscliinvocation reports whether the process arguments ask for a command line
+// subcommand rather than the gui (which accepts an optional folder path).
+func isCLIInvocation(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	if args[1] == "-h" || args[1] == "--help" {
+		return true
+	}
+	return findCLICommand(args[1]) != nil
+}
+
+func findCLICommand(name string) *cliCommand {
+	for i := range cliCommands {
+		if cliCommands[i].name == name {
+			return &cliCommands[i]
+		}
+	}
+	return nil
+}
+
+// runcli executes a subcommand and returns the process exit code.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return runHelpCommand(nil, stdout, stderr)
+	}
+	cmd := findCLICommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "shotgun: unknown command %q\n\n", args[0])
+		printCLIUsage(stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: shotgun [folder]            open the gui (optionally with a folder)")
+	fmt.Fprintln(w, "       shotgun <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range cliCommands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run 'shotgun <command> -h' for the flags of a command.")
+}
+
+func runHelpCommand(_ []string, stdout, _ io.Writer) int {
+	printCLIUsage(stdout)
+	return exitOK
+}
+
+// stringListFlag collects the values of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringListFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// parseTransformFlags turns -transform values ("name,name" or "ext=name,name") into
+// contextoptions.transforms; values without an extension apply to every file.
+func parseTransformFlags(values []string) map[string][]string {
+	if len(values) == 0 {
+		return nil
+	}
+	transforms := make(map[string][]string)
+	for _, v := range values {
+		ext, names := transformAllFiles, v
+		if eq := strings.Index(v, "="); eq >= 0 {
+			ext, names = v[:eq], v[eq+1:]
+		}
+		transforms[ext] = append(transforms[ext], strings.Split(names, ",")...)
+	}
+	return transforms
+}
+
+// ignoreFlags are shared by every command that evaluates ignore rules.
+type ignoreFlags struct {
+	noGitignore    bool
+	noCustomIgnore bool
+	verbose        bool
+	symlinks       symlinkPolicyFlag
+	hardExclude    hardExcludeFlag
+}
+
+// symlinkPolicyFlag is a -symlinks value, validated while the flags are parsed.
+type symlinkPolicyFlag string
+
+func (f *symlinkPolicyFlag) String() string { return string(*f) }
+
+func (f *symlinkPolicyFlag) Set(v string) error {
+	if _, err := validateSymlinkPolicy(v); err != nil {
+		return err
+	}
+	*f = symlinkPolicyFlag(v)
+	return nil
+}
+
+// hardExcludeFlag collects -hard-exclude values (comma-separated directory names).
+// set tells an empty value, which hides nothing, from an absent flag.
+type hardExcludeFlag struct {
+	dirs []string
+	set  bool
+}
+
+func (f *hardExcludeFlag) String() string { return strings.Join(f.dirs, ",") }
+
+func (f *hardExcludeFlag) Set(v string) error {
+	dirs, err := normalizeHardExcludedDirs(append(f.dirs, strings.Split(v, ",")...))
+	if err != nil {
+		return err
+	}
+	f.dirs, f.set = dirs, true
+	return nil
+}
+
+func (f *ignoreFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.noGitignore, "no-gitignore", false, "do not apply the project's .gitignore")
+	fs.BoolVar(&f.noCustomIgnore, "no-custom-ignore", false, "do not apply the custom ignore rules from the settings")
+	fs.BoolVar(&f.verbose, "v", false, "print debug and info log lines to stderr")
+	fs.Var(&f.symlinks, "symlinks", "how to treat symbolic links: skip, show (list with target) or follow (only inside the root); default from the settings")
+	fs.Var(&f.hardExclude, "hard-exclude", "directory names that are never listed (comma-separated, repeatable), replacing the list from the settings; '' shows every directory")
+}
+
+func newCLIFlagSet(name, argsUsage string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: shotgun %s [flags] %s\n\nflags:\n", name, argsUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parsecliflags parses args and maps flag errors to exit codes. unlike fs.parse it
+// accepts flags after positional arguments ("shotgun context ./repo -out ctx.txt").
+// ok is false when the command should return code immediately (usage error or -h).
+func parseCLIFlags(fs *flag.FlagSet, args []string) (positional []string, code int, ok bool) {
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK, false
+			}
+			return nil, exitUsage, false
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, exitOK, true
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newheadlessapp creates an app that logs to stderr instead of the wails runtime
+// and loads the same settings (custom ignore rules etc.) as the gui.
+func newHeadlessApp(ctx context.Context, stderr io.Writer, flags ignoreFlags) *App {
+	a := NewApp()
+	minLevel := logLevelWarning
+	if flags.verbose {
+		minLevel = logLevelDebug
+	}
+	a.headless = &headlessLogger{out: stderr, minLevel: minLevel}
+	a.initState(ctx)
+	a.useGitignore = !flags.noGitignore
+	a.useCustomIgnore = !flags.noCustomIgnore
+	if flags.symlinks != "" {
+		a.settings.SymlinkPolicy = string(flags.symlinks) // for this run only, not saved
+	}
+	if flags.hardExclude.set {
+		a.settings.HardExcludedDirs = flags.hardExclude.dirs // for this run only, not saved
+		a.settings.ProjectHardExcludedDirs = nil
+	}
+	return a
+}
+
+// resolvecliroot validates the root directory argument and returns its absolute path.
+func resolveCLIRoot(arg string) (string, error) {
+	root, err := filepath.Abs(strings.Trim(arg, "\""))
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() && !isArchivePath(root) {
+		return "", fmt.Errorf("%s is neither a directory nor a zip or tar archive", root)
+	}
+	return root, nil
+}
+
+// collectignoredpaths mirrors the frontend: a node is excluded when it is matched by an
+// active ignore source. excluded directories are reported once, without their children.
+func collectIgnoredPaths(nodes []*FileNode, useGitignore, useCustomIgnore bool, into []string) []string {
+	for _, node := range nodes {
+		if (useGitignore && node.IsGitignored) || (useCustomIgnore && node.IsCustomIgnored) {
+			into = append(into, node.RelPath)
+			continue
+		}
+		into = collectIgnoredPaths(node.Children, useGitignore, useCustomIgnore, into)
+	}
+	return into
+}
+
+// progressprinter renders shotguncontextgenerationprogress events on stderr. on a
+// terminal it redraws a single line; otherwise only the final count is printed.
+type progressPrinter struct {
+	out      io.Writer
+	terminal bool
+	mu       sync.Mutex
+	last     time.Time
+	printed  bool
+}
+
+func newProgressPrinter(out *os.File) *progressPrinter {
+	p := &progressPrinter{out: out}
+	if fi, err := out.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		p.terminal = true
+	}
+	return p
+}
+
+func (p *progressPrinter) onEvent(eventName string, optionalData ...interface{}) {
+	if eventName != "shotgunContextGenerationProgress" || len(optionalData) == 0 {
+		return
+	}
+	progress, ok := optionalData[0].(map[string]int)
+	if !ok {
+		return
+	}
+	current, total := progress["current"], progress["total"]
+	done := total > 0 && current >= total
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.terminal {
+		if done && !p.printed {
+			fmt.Fprintf(p.out, "shotgun: processed %d/%d items\n", current, total)
+			p.printed = true
+		}
+		return
+	}
+	if !done && time.Since(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = time.Now()
+	fmt.Fprintf(p.out, "\rshotgun: generating context %d/%d", current, total)
+	p.printed = true
+}
+
+// finish terminates the redrawn progress line so later output starts on a new line.
+func (p *progressPrinter) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminal && p.printed {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func runContextCommand(args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("context", "<dir or archive> | <alias=dir>...", stderr)
+	var flags ignoreFlags
+	flags.register(fs)
+	var excludes stringListFlag
+	fs.Var(&excludes, "exclude", "relative path to exclude (repeatable), e.g. -exclude web/")
+	var includes stringListFlag
+	changedOnly := fs.Bool("changed", false, "only inline files that are modified, staged or untracked according to git")
+	baseRef := fs.String("base", "", "compare against this git ref instead of HEAD (implies -changed)")
+	neighbors := fs.Bool("neighbors", false, "with -changed, also inline the other files in each changed file's directory")
+	withDiffs := fs.Bool("diff", false, "append the git diff of each changed file to its block")
+	noRedact := fs.Bool("no-redact", false, "do not replace detected secrets with placeholders")
+	noDedup := fs.Bool("no-dedup", false, "write every file in full, also copies of files written before")
+	var transforms stringListFlag
+	fs.Var(&transforms, "transform", "token-saving transforms as [ext=]name,name (repeatable), e.g. -transform collapse-blank-lines -transform .go=strip-comments")
+	fs.Var(&includes, "include", "gitignore-style glob; only matching files are inlined (repeatable), e.g. -include 'cmd/api/*.go'")
+	outPath := fs.String("out", "", "write the context to this file instead of stdout")
+	quiet := fs.Bool("quiet", false, "do not print progress to stderr")
+	reportPath := fs.String("report", "", "write the generation report as json to this file")
+	snapshotPath := fs.String("snapshot", "", "write the file hashes of the context to this file, for a later -since")
+	sincePath := fs.String("since", "", "only inline the files added or modified since the context of this -snapshot file")
+	ref := fs.String("ref", "", "read the roots as they are at this git branch, tag or commit instead of the working tree")
+	var opts ContextOptions
+	fs.StringVar(&opts.Format, "format", contextFormatXML, "output format: xml, markdown or json")
+	fs.IntVar(&opts.TokenBudget, "token-budget", 0, "pack the context into roughly this many tokens (0 = no budget)")
+	fs.BoolVar(&opts.LineNumbers, "line-numbers", false, "prefix every line of the inlined files with its line number")
+	fs.IntVar(&opts.ExcerptLines, "excerpt-lines", 0, "lines kept at the start and end of files over the size limit (0 = 200, -1 = leave such files out)")
+	var pins stringListFlag
+	fs.Var(&pins, "pin", "relative path packed first when a token budget is set (repeatable)")
+	var outlinePaths stringListFlag
+	fs.Var(&outlinePaths, "outline", "relative file or directory whose go files are inlined as signatures without bodies (repeatable, . for all)")
+	fs.StringVar(&opts.NotebookOutputs, "notebook-outputs", notebookOutputsText, "how jupyter notebooks are inlined: text (cells with truncated text outputs), none (cells only) or raw (the json)")
+	var goPackages stringListFlag
+	fs.Var(&goPackages, "go-package", "go package (import path or relative directory) to inline with every package it imports from the module (repeatable)")
+	var goDeps GoDependencyOptions
+	fs.BoolVar(&goDeps.IncludeTests, "go-package-tests", false, "with -go-package, also inline _test.go files and follow their imports")
+	fs.IntVar(&goDeps.MaxDepth, "go-package-depth", 0, "with -go-package, import levels to follow (0 = all)")
+	positional, code, ok := parseCLIFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	roots, err := parseCLIRoots(positional)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+	roots = rootsAtRef(roots, *ref)
+	if _, err := newContextFormatter(opts.Format); err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+	if err := validNotebookOutputs(opts.NotebookOutputs); err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+	opts.Transforms = parseTransformFlags(transforms)
+	if _, err := newContentTransformer(opts.Transforms); err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := newHeadlessApp(ctx, stderr, flags)
+	var progress *progressPrinter
+	if !*quiet {
+		if f, ok := stderr.(*os.File); ok {
+			progress = newProgressPrinter(f)
+			a.onEvent = progress.onEvent
+		}
+	}
+
+	tree, err := a.listWorkspace(roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+	var excludedPaths []string
+	for _, rootNode := range tree {
+		excludedPaths = collectIgnoredPaths(rootNode.Children, a.useGitignore, a.useCustomIgnore, excludedPaths)
+	}
+	for _, p := range excludes {
+		excludedPaths = append(excludedPaths, normalizeRelPath(p))
+	}
+
+	opts.PinnedPaths = pins
+	opts.OutlinePaths = outlinePaths
+	opts.IncludePatterns = includes
+	if len(goPackages) > 0 {
+		deps, err := a.SelectGoPackageDependencies(roots, goPackages, goDeps)
+		if err != nil {
+			fmt.Fprintf(stderr, "shotgun: %v\n", err)
+			return exitFailure
+		}
+		for _, missing := range deps.Missing {
+			fmt.Fprintf(stderr, "shotgun: go package %s not found\n", missing)
+		}
+		if len(deps.Files) == 0 {
+			fmt.Fprintln(stderr, "shotgun: the go packages have no files")
+			return exitFailure
+		}
+		if !*quiet {
+			fmt.Fprintf(stderr, "shotgun: selected %d go packages with %d files\n", len(deps.Packages), len(deps.Files))
+		}
+		opts.IncludePatterns = append(opts.IncludePatterns, deps.IncludePatterns...)
+	}
+	opts.ChangedOnly = *changedOnly || *baseRef != ""
+	opts.BaseRef = *baseRef
+	opts.IncludeNeighbors = *neighbors
+	opts.IncludeDiffs = *withDiffs
+	opts.DisableRedaction = *noRedact
+	opts.DisableDedup = *noDedup
+	if *sincePath != "" {
+		base, err := readSnapshotFile(*sincePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "shotgun: %v\n", err)
+			return exitFailure
+		}
+		a.snapshots = newSnapshotStore()
+		a.snapshots.put(base)
+		opts.SinceSnapshot = base.ID
+	}
+	// the context is streamed to a temporary file first so a failed run never leaves a
+	// truncated -out file behind or half a context on stdout.
+	tmpDir := os.TempDir()
+	if *outPath != "" {
+		tmpDir = filepath.Dir(*outPath)
+	}
+	tmpPath, report, err := a.generateContextFile(ctx, tmpDir, ".shotgun-context-*.tmp", roots, excludedPaths, opts)
+	if progress != nil {
+		progress.finish()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		switch {
+		case ctx.Err() != nil:
+			return exitInterrupted
+		case errors.Is(err, ErrContextTooLong):
+			if report != nil && report.Composition != nil {
+				printCLIComposition(stderr, report.Composition)
+				if *reportPath != "" {
+					if err := writeCLIReport(*reportPath, report); err != nil {
+						fmt.Fprintf(stderr, "shotgun: %v\n", err)
+					}
+				}
+			}
+			return exitContextTooLong
+		default:
+			return exitFailure
+		}
+	}
+
+	if err := writeCLIOutput(tmpPath, *outPath, stdout); err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+	if *outPath != "" && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: wrote %d bytes to %s\n", report.Size, *outPath)
+	}
+	if report.Secrets != nil && report.Secrets.Redacted > 0 && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: redacted %d secrets in %d files\n", report.Secrets.Redacted, len(report.Secrets.Files))
+		for _, f := range report.Secrets.Files {
+			for _, finding := range f.Findings {
+				fmt.Fprintf(stderr, "shotgun:   %s:%d: %s\n", f.Path, finding.Line, finding.Kind)
+			}
+		}
+	}
+	if report.Transforms != nil && !*quiet {
+		for _, saving := range report.Transforms.Savings {
+			fmt.Fprintf(stderr, "shotgun: %s saved %d bytes (~%d tokens) in %d files\n", saving.Name, saving.BytesSaved, saving.TokensSaved, saving.Files)
+		}
+	}
+	if report.Outlines != nil && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: go outlines saved %d bytes (~%d tokens) in %d files\n", report.Outlines.BytesSaved, report.Outlines.TokensSaved, report.Outlines.Files)
+	}
+	if report.Notebooks != nil && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: flattening notebooks saved %d bytes (~%d tokens) in %d files\n", report.Notebooks.BytesSaved, report.Notebooks.TokensSaved, report.Notebooks.Files)
+	}
+	if c := report.Composition; c != nil && c.DuplicateFiles > 0 && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: %d duplicate files written as references, saved %d bytes (~%d tokens)\n", c.DuplicateFiles, c.DuplicateSavedBytes, c.DuplicateSavedTokens)
+	}
+	for _, e := range report.Excerpted {
+		if !*quiet {
+			fmt.Fprintf(stderr, "shotgun: %s is too large (%d bytes, %d lines), inlined lines %s\n", e.Path, e.Size, e.Lines, e.Shown)
+		}
+	}
+	if report.Git != nil && opts.ChangedOnly && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: %d files changed since %s, %d deleted\n", len(report.Git.Changed), report.Git.BaseRef, len(report.Git.Deleted))
+	}
+	if report.Packing != nil && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: token budget %d: inlined %d files (~%d tokens), omitted %d\n",
+			report.Packing.TokenBudget, report.Packing.IncludedFiles, report.Packing.UsedTokens, len(report.Packing.Omitted))
+		if over := report.Packing.OverBudgetTokens; over > 0 {
+			fmt.Fprintf(stderr, "shotgun: the written context has ~%d tokens, %d over the budget (the plan uses file sizes on disk)\n",
+				report.Packing.WrittenTokens, over)
+		}
+	}
+	if d := report.Delta; d != nil && !*quiet {
+		fmt.Fprintf(stderr, "shotgun: changes since %s: %d added, %d modified, %d removed, %d unchanged\n",
+			d.Since, len(d.Added), len(d.Modified), len(d.Removed), d.Unchanged)
+		if len(d.Omitted) > 0 {
+			fmt.Fprintf(stderr, "shotgun: %d changed files were left out for the token budget\n", len(d.Omitted))
+		}
+	}
+	if *snapshotPath != "" && report.snapshot != nil {
+		report.snapshot.ID = report.snapshot.Created.UTC().Format("20060102T150405Z")
+		if err := writeSnapshotFile(*snapshotPath, report.snapshot); err != nil {
+			fmt.Fprintf(stderr, "shotgun: %v\n", err)
+			return exitFailure
+		}
+		report.Snapshot = report.snapshot.ID
+	}
+	if *reportPath != "" {
+		if err := writeCLIReport(*reportPath, report); err != nil {
+			fmt.Fprintf(stderr, "shotgun: %v\n", err)
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// writeclioutput moves the generated context at tmppath to outpath, or copies it to
+// stdout when no output file was requested. tmppath is gone afterwards.
+func writeCLIOutput(tmpPath, outPath string, stdout io.Writer) error {
+	if outPath != "" {
+		if err := os.Chmod(tmpPath, 0644); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		if err := os.Rename(tmpPath, outPath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		return nil
+	}
+	defer os.Remove(tmpPath)
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	os.Remove(tmpPath) // unlink early where the os allows it, in case stdout is a closed pipe
+	_, err = io.Copy(stdout, f)
+	return err
+}
+
+// cliCompositionRows is how many directories, extensions and files printCLIComposition
+// lists; the json report has the full breakdown.
+const cliCompositionRows = 5
+
+// printCLIComposition tells the user what filled a context that is too long.
+func printCLIComposition(w io.Writer, c *ContextComposition) {
+	fmt.Fprintf(w, "shotgun: tree %d bytes, %d files %d bytes", c.TreeBytes, c.Files, c.FileBytes)
+	if c.UnreadFiles > 0 {
+		fmt.Fprintf(w, " (%d not read, counted by size on disk)", c.UnreadFiles)
+	}
+	if c.Incomplete {
+		fmt.Fprint(w, ", tree walk stopped early")
+	}
+	fmt.Fprintln(w)
+	for _, group := range []struct {
+		title   string
+		entries []CompositionEntry
+	}{
+		{"largest directories", c.Directories},
+		{"largest extensions", c.Extensions},
+		{"largest files", c.LargestFiles},
+	} {
+		if len(group.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "shotgun: %s:\n", group.title)
+		for _, e := range group.entries[:min(len(group.entries), cliCompositionRows)] {
+			fmt.Fprintf(w, "shotgun:   %-40s %12d bytes ~%d tokens (%d files)\n", e.Path, e.Bytes, e.EstimatedTokens, e.Files)
+		}
+	}
+}
+
+func writeCLIReport(reportPath string, report *ContextReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reportPath, append(data, '\n'), 0644)
+}
+
+func runListCommand(args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("ls", "<dir or archive> | <alias=dir>...", stderr)
+	var flags ignoreFlags
+	flags.register(fs)
+	ref := fs.String("ref", "", "list the roots as they are at this git branch, tag or commit instead of the working tree")
+	positional, code, ok := parseCLIFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	roots, err := parseCLIRoots(positional)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+	roots = rootsAtRef(roots, *ref)
+
+	a := newHeadlessApp(context.Background(), stderr, flags)
+	tree, err := a.listWorkspace(roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+	for i, rootNode := range tree {
+		label := roots[i].treeLabel() // the node name adds the ref of a root at a git ref
+		fmt.Fprintln(stdout, label+string(os.PathSeparator)+strings.TrimPrefix(rootNode.Name, label))
+		printCLITree(stdout, rootNode.Children, "", a.useGitignore, a.useCustomIgnore)
+	}
+	return exitOK
+}
+
+func printCLITree(w io.Writer, nodes []*FileNode, prefix string, useGitignore, useCustomIgnore bool) {
+	for i, node := range nodes {
+		branch, nextPrefix := "├── ", prefix+"│   "
+		if i == len(nodes)-1 {
+			branch, nextPrefix = "└── ", prefix+"    "
+		}
+		var markers []string
+		if useGitignore && node.IsGitignored {
+			markers = append(markers, "[gitignored]")
+		}
+		if useCustomIgnore && node.IsCustomIgnored {
+			markers = append(markers, "[custom-ignored]")
+		}
+		if node.GitStatus != "" {
+			markers = append(markers, "["+node.GitStatus+"]")
+		}
+		line := prefix + branch + node.Name
+		if node.SymlinkTarget != "" {
+			line += " -> " + node.SymlinkTarget
+		}
+		if node.SymlinkNote != "" {
+			markers = append(markers, "["+node.SymlinkNote+"]")
+		}
+		if len(markers) > 0 {
+			line += " " + strings.Join(markers, " ")
+		}
+		fmt.Fprintln(w, line)
+		printCLITree(w, node.Children, nextPrefix, useGitignore, useCustomIgnore)
+	}
+}
+
+func runCheckIgnoreCommand(args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("check-ignore", "<dir or archive> <path>...", stderr)
+	var flags ignoreFlags
+	flags.register(fs)
+	positional, code, ok := parseCLIFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) < 2 {
+		fs.Usage()
+		return exitUsage
+	}
+	rootDir, err := resolveCLIRoot(positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitUsage
+	}
+
+	a := newHeadlessApp(context.Background(), stderr, flags)
+	tree, err := a.ListFiles(rootDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+	ignored := make(map[string]bool)
+	for _, p := range collectIgnoredPaths(tree[0].Children, a.useGitignore, a.useCustomIgnore, nil) {
+		ignored[p] = true
+	}
+
+	// like git check-ignore: print the ignored paths, exit 0 if any matched, 1 otherwise.
+	code = exitFailure
+	for _, arg := range positional[1:] {
+		rel := normalizeRelPath(arg)
+		if filepath.IsAbs(rel) {
+			if r, err := filepath.Rel(rootDir, rel); err == nil {
+				rel = r
+			}
+		}
+		if isPathOrParentIn(rel, ignored) {
+			fmt.Fprintln(stdout, arg)
+			code = exitOK
+		}
+	}
+	return code
+}
+
+// ispathorparentin reports whether relpath or any of its parent directories is in set.
+func isPathOrParentIn(relPath string, set map[string]bool) bool {
+	for p := relPath; p != "." && p != "" && p != string(os.PathSeparator); p = filepath.Dir(p) {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func runSplitDiffCommand(args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("split-diff", "", stderr)
+	lineLimit := fs.Int("lines", 500, "approximate maximum number of lines per split")
+	inPath := fs.String("in", "", "read the diff from this file instead of stdin")
+	outDir := fs.String("out-dir", "", "write each split to <out-dir>/split-NNN.diff instead of stdout")
+	verbose := fs.Bool("v", false, "print debug and info log lines to stderr")
+	positional, code, ok := parseCLIFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var data []byte
+	var err error
+	if *inPath != "" {
+		data, err = os.ReadFile(*inPath)
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+
+	a := NewApp()
+	minLevel := logLevelWarning
+	if *verbose {
+		minLevel = logLevelDebug
+	}
+	a.headless = &headlessLogger{out: stderr, minLevel: minLevel}
+	splits, err := a.SplitShotgunDiff(string(data), *lineLimit)
+	if err != nil {
+		fmt.Fprintf(stderr, "shotgun: %v\n", err)
+		return exitFailure
+	}
+
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			fmt.Fprintf(stderr, "shotgun: %v\n", err)
+			return exitFailure
+		}
+		for i, split := range splits {
+			name := filepath.Join(*outDir, fmt.Sprintf("split-%03d.diff", i+1))
+			if err := os.WriteFile(name, []byte(split+"\n"), 0644); err != nil {
+				fmt.Fprintf(stderr, "shotgun: %v\n", err)
+				return exitFailure
+			}
+			fmt.Fprintln(stdout, name)
+		}
+		return exitOK
+	}
+
+	// git apply skips text before the first "diff --git" line, so the separators
+	// keep each split usable on its own.
+	for i, split := range splits {
+		fmt.Fprintf(stdout, "# split %d/%d\n%s\n", i+1, len(splits), split)
+	}
+	return exitOK
+}
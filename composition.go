@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- context composition ---
+
+// every report carries a breakdown of where the bytes of the context went, so a context
+// that is too long for the limit or the model can be trimmed where it matters. each file
+// is measured as written, tags and diff included; the tree is counted on its own.
+//
+// when generation stops with ErrContextTooLong, the files that were never written are
+// counted by their size on disk (UnreadFiles) and their lines stay unknown.
+
+const (
+	compositionTopFiles = 20
+	compositionTopDirs  = 50
+)
+
+// compositionNoExtension groups files without an extension.
+const compositionNoExtension = "(none)"
+
+// CompositionEntry is the share of one directory, extension or file.
+type CompositionEntry struct {
+	Path            string `json:"path"` // forward slashes; ".go" style for extensions
+	Files           int    `json:"files"`
+	Bytes           int64  `json:"bytes"`
+	Lines           int    `json:"lines"`
+	EstimatedTokens int    `json:"estimatedTokens"`
+}
+
+// ContextComposition breaks a context down by directory, extension and file.
+type ContextComposition struct {
+	TreeBytes    int64              `json:"treeBytes"`
+	TreeLines    int                `json:"treeLines"`
+	Files        int                `json:"files"`
+	FileBytes    int64              `json:"fileBytes"`
+	Directories  []CompositionEntry `json:"directories"`  // including subdirectories, largest first
+	Extensions   []CompositionEntry `json:"extensions"`   // largest first
+	LargestFiles []CompositionEntry `json:"largestFiles"` // largest first
+	OmittedFiles int                `json:"omittedFiles"` // replaced by a budget or too-large marker
+	BinaryFiles  int                `json:"binaryFiles"`  // replaced by the non-text placeholder
+	UnreadFiles  int                `json:"unreadFiles"`  // counted by their size on disk, see above
+	Incomplete   bool               `json:"incomplete"`   // the tree walk stopped early, so files are missing
+	// files written as a reference to an identical earlier file, and the bytes and
+	// estimated tokens of the bodies that were not repeated (see dedup.go)
+	DuplicateFiles       int   `json:"duplicateFiles"`
+	DuplicateSavedBytes  int64 `json:"duplicateSavedBytes"`
+	DuplicateSavedTokens int   `json:"duplicateSavedTokens"`
+}
+
+type compositionBuilder struct {
+	c     *ContextComposition
+	dirs  map[string]*CompositionEntry
+	exts  map[string]*CompositionEntry
+	files []CompositionEntry
+}
+
+func newCompositionBuilder() *compositionBuilder {
+	return &compositionBuilder{
+		c:    &ContextComposition{},
+		dirs: make(map[string]*CompositionEntry),
+		exts: make(map[string]*CompositionEntry),
+	}
+}
+
+// addFile accounts one file block of bytes and lines. relPath is os-specific.
+func (b *compositionBuilder) addFile(relPath string, bytes int64, lines int) {
+	slashPath := filepath.ToSlash(relPath)
+	b.c.Files++
+	b.c.FileBytes += bytes
+	b.files = append(b.files, CompositionEntry{Path: slashPath, Files: 1, Bytes: bytes, Lines: lines})
+
+	ext := strings.ToLower(filepath.Ext(slashPath))
+	if ext == "" {
+		ext = compositionNoExtension
+	}
+	addTo(b.exts, ext, bytes, lines)
+	for dir := filepath.Dir(relPath); dir != "." && dir != string(os.PathSeparator); dir = filepath.Dir(dir) {
+		addTo(b.dirs, filepath.ToSlash(dir)+"/", bytes, lines)
+	}
+}
+
+// addUnread accounts a file that was never written, by its size on disk.
+func (b *compositionBuilder) addUnread(f contextFileEntry) {
+	b.addFile(f.relPath, f.size, 0)
+	b.c.UnreadFiles++
+}
+
+// addDuplicate accounts the body of a file that was written as a reference instead.
+func (b *compositionBuilder) addDuplicate(bodyBytes int) {
+	b.c.DuplicateFiles++
+	b.c.DuplicateSavedBytes += int64(bodyBytes)
+}
+
+func addTo(m map[string]*CompositionEntry, key string, bytes int64, lines int) {
+	e := m[key]
+	if e == nil {
+		e = &CompositionEntry{Path: key}
+		m[key] = e
+	}
+	e.Files++
+	e.Bytes += bytes
+	e.Lines += lines
+}
+
+// build sorts and trims the collected entries and returns the composition.
+func (b *compositionBuilder) build() *ContextComposition {
+	b.c.Directories = largestEntries(b.dirs, compositionTopDirs)
+	b.c.Extensions = largestEntries(b.exts, 0)
+	b.c.DuplicateSavedTokens = estimateTokens(int(b.c.DuplicateSavedBytes))
+	sortCompositionEntries(b.files)
+	b.c.LargestFiles = b.files[:min(len(b.files), compositionTopFiles)]
+	for i := range b.c.LargestFiles {
+		b.c.LargestFiles[i].EstimatedTokens = estimateTokens(int(b.c.LargestFiles[i].Bytes))
+	}
+	return b.c
+}
+
+// largestEntries returns the entries of m, largest first, at most limit (0: all).
+func largestEntries(m map[string]*CompositionEntry, limit int) []CompositionEntry {
+	entries := make([]CompositionEntry, 0, len(m))
+	for _, e := range m {
+		e.EstimatedTokens = estimateTokens(int(e.Bytes))
+		entries = append(entries, *e)
+	}
+	sortCompositionEntries(entries)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+func sortCompositionEntries(entries []CompositionEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Path < entries[j].Path
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- incremental regeneration ---
+
+// the gui regenerates the context after every change the watcher reports, usually for a
+// single file. contentCache keeps the processed block of every file from the last run
+// (loaded, transcoded, transformed and scanned for secrets), so the next run only reads
+// files whose size or modification time changed, or that the watcher named. it also
+// remembers the item count of the last run, which replaces the separate counting walk
+// as the progress total: an estimate once files were added or removed, but progress
+// is only a display.
+//
+// entries are only valid for the options that shape a loaded block (see
+// contentCacheFingerprint); another fingerprint empties the cache. entries not seen
+// by a finished run are dropped, so the cache never holds more than one context.
+// git diffs are not cached: they depend on the repository, not on the file alone.
+
+type cachedBlock struct {
+	size    int64
+	modTime time.Time
+	loaded  loadedFile // without the diff
+	runID   uint64     // the last run that used the entry
+}
+
+type contentCache struct {
+	mu          sync.Mutex
+	rootDir     string
+	fingerprint string
+	blocks      map[string]*cachedBlock // by absolute path
+	lastTotal   int                     // progress items of the last finished run, 0 when unknown
+	runID       uint64
+}
+
+func newContentCache() *contentCache {
+	return &contentCache{blocks: make(map[string]*cachedBlock)}
+}
+
+// contentCacheFingerprint describes the options that change what the read pool
+// produces for a file. fmt prints maps with sorted keys, so equal configs match.
+func contentCacheFingerprint(opts ContextOptions) string {
+	return fmt.Sprintf("transforms=%v excerpt=%d redact=%t outline=%q notebooks=%q", opts.Transforms, excerptLineCount(opts), !opts.DisableRedaction, opts.OutlinePaths, opts.NotebookOutputs)
+}
+
+// beginRun prepares the cache for a run over rootDir and returns the run id and the
+// progress total of the previous run (0 when the items must be counted). it is nil-safe.
+func (c *contentCache) beginRun(rootDir string, opts ContextOptions) (uint64, int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fingerprint := contentCacheFingerprint(opts)
+	if c.rootDir != rootDir || c.fingerprint != fingerprint {
+		c.rootDir = rootDir
+		c.fingerprint = fingerprint
+		c.blocks = make(map[string]*cachedBlock)
+		c.lastTotal = 0
+	}
+	c.runID++
+	return c.runID, c.lastTotal
+}
+
+// get returns the cached block for f if the file is unchanged since it was stored.
+func (c *contentCache) get(runID uint64, f contextFileEntry) (loadedFile, bool) {
+	if c == nil {
+		return loadedFile{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.blocks[f.absPath]
+	if !ok || b.size != f.size || !b.modTime.Equal(f.modTime) || f.modTime.IsZero() {
+		return loadedFile{}, false
+	}
+	b.runID = runID
+	return b.loaded, true
+}
+
+// put stores the block loaded for f. the diff is left out, and the attribute slice is
+// clipped so the writer's appends never reach into the cached array.
+func (c *contentCache) put(runID uint64, f contextFileEntry, loaded loadedFile) {
+	if c == nil || f.modTime.IsZero() {
+		return
+	}
+	loaded.block.Diff = ""
+	loaded.diffSecrets = nil
+	loaded.block.Attrs = loaded.block.Attrs[:len(loaded.block.Attrs):len(loaded.block.Attrs)]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks[f.absPath] = &cachedBlock{size: f.size, modTime: f.modTime, loaded: loaded, runID: runID}
+}
+
+// finishRun drops the entries the run did not use and remembers its progress total.
+// runs that were superseded by a newer one leave the cache alone.
+func (c *contentCache) finishRun(runID uint64, totalItems int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if runID != c.runID {
+		return
+	}
+	for path, b := range c.blocks {
+		if b.runID != runID {
+			delete(c.blocks, path)
+		}
+	}
+	c.lastTotal = totalItems
+}
+
+// invalidate forgets path and, for a directory, everything below it. the watcher calls
+// it for every relevant event, which also catches edits that keep size and mtime.
+func (c *contentCache) invalidate(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.blocks, path)
+	prefix := path + string(os.PathSeparator)
+	for p := range c.blocks {
+		if strings.HasPrefix(p, prefix) {
+			delete(c.blocks, p)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// --- per-request context generation options ---
+
+// contextoptions holds the optional knobs of a single context generation request.
+// the zero value inlines every non-excluded file, with secrets redacted.
+type ContextOptions struct {
+	// format selects the output writer: "xml" (default), "markdown" or "json".
+	Format string `json:"format"`
+	// tokenbudget, when positive, packs the context so that it fits into roughly this
+	// many tokens. the tree is always kept; files that do not fit are replaced by
+	// "[omitted: budget]" markers.
+	TokenBudget int `json:"tokenBudget"`
+	// pinnedpaths are relative paths (files or directories) that are packed before
+	// anything else when a token budget is set.
+	PinnedPaths []string `json:"pinnedPaths"`
+	// includepatterns, when not empty, switch to allowlist mode: only files matching
+	// these gitignore-style globs are inlined. excludedpaths still take precedence and
+	// the tree keeps showing everything that was left out (see selection.go).
+	IncludePatterns []string `json:"includePatterns"`
+	// changedonly inlines only files that git reports as modified, staged or untracked
+	// relative to baseref (head when empty). includeneighbors adds the other files of
+	// every directory with a change; includediffs appends each changed file's git diff.
+	ChangedOnly      bool   `json:"changedOnly"`
+	BaseRef          string `json:"baseRef"`
+	IncludeNeighbors bool   `json:"includeNeighbors"`
+	IncludeDiffs     bool   `json:"includeDiffs"`
+	// disableredaction turns off the secret scan (see redact.go). only meant for contexts
+	// that never leave the machine.
+	DisableRedaction bool `json:"disableRedaction"`
+	// disablededup writes the body of every file, also when an identical file was
+	// written before (see dedup.go).
+	DisableDedup bool `json:"disableDedup"`
+	// transforms maps a file extension (".go") or "*" for every file to the token-saving
+	// transforms applied to inlined contents, e.g. {"*": ["trim-trailing-whitespace"],
+	// ".go": ["strip-comments"]}. see transforms.go for the supported names.
+	Transforms map[string][]string `json:"transforms"`
+	// excerptlines is how many lines are kept at the start and at the end of files over
+	// the size limit (see excerpt.go); 0 means defaultexcerptlines and a negative value
+	// replaces such files by a "[file omitted: too large]" marker.
+	ExcerptLines int `json:"excerptLines"`
+	// linenumbers prefixes every content line with its number (see linenumbers.go). the
+	// numbers count the lines as inlined, so they only match the file on disk when no
+	// transform removes lines.
+	LineNumbers bool `json:"lineNumbers"`
+	// outlinepaths are relative paths (files or directories, "." for all) whose go files
+	// are inlined as an outline: declarations and signatures without function bodies
+	// (see outline.go).
+	OutlinePaths []string `json:"outlinePaths"`
+	// notebookoutputs selects how jupyter notebooks are inlined (see notebook.go): "text"
+	// (the default when empty) flattens them into cells with truncated text outputs,
+	// "none" drops the outputs and "raw" inlines the notebook json unchanged.
+	NotebookOutputs string `json:"notebookOutputs"`
+	// sincesnapshot, when set, generates a delta context: only the files added or
+	// modified since the context with this snapshot id, after a header that lists the
+	// removed paths (see delta.go).
+	SinceSnapshot string `json:"sinceSnapshot"`
+}
+
+// contextreport describes a finished generation job. it is emitted to the frontend as
+// the shotguncontextreport event right after shotguncontextgenerated.
+type ContextReport struct {
+	RootDir         string              `json:"rootDir"`         // the first root of a workspace
+	Roots           []WorkspaceRoot     `json:"roots,omitempty"` // only set for a workspace
+	Format          string              `json:"format"`
+	Size            int64               `json:"size"` // bytes written
+	Lines           int                 `json:"lines"`
+	EstimatedTokens int                 `json:"estimatedTokens"`
+	IncludePatterns []string            `json:"includePatterns,omitempty"` // echoed from the request
+	Packing         *PackingReport      `json:"packing,omitempty"`         // only set when a token budget was requested
+	Git             *GitChangesReport   `json:"git,omitempty"`             // only set in changed files mode
+	Secrets         *SecretsReport      `json:"secrets,omitempty"`         // nil when redaction is disabled
+	Transforms      *TransformReport    `json:"transforms,omitempty"`      // only set when transforms were requested
+	Outlines        *OutlineReport      `json:"outlines,omitempty"`        // only set when outline paths were requested
+	Notebooks       *NotebookReport     `json:"notebooks,omitempty"`       // only set when notebooks were flattened
+	Excerpted       []ExcerptedFile     `json:"excerpted,omitempty"`       // oversized files inlined partially
+	ReusedFiles     int                 `json:"reusedFiles,omitempty"`     // blocks taken from the incremental cache
+	Composition     *ContextComposition `json:"composition,omitempty"`     // where the bytes went, also set for a context that is too long
+	Delta           *DeltaReport        `json:"delta,omitempty"`           // only set for a delta context
+	Snapshot        string              `json:"snapshot,omitempty"`        // id of the stored snapshot of this context
+
+	snapshot *ContextSnapshot // file hashes of the context, stored by the caller
+}
+
+// normalizerelpath converts a user or frontend supplied relative path ("web/", "./src/a.go")
+// into the os-specific form produced by filepath.rel during tree walks.
+func normalizeRelPath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	return filepath.Clean(filepath.FromSlash(p))
+}
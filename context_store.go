@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/adrg/xdg"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// --- on-disk context storage ---
+
+// generated contexts can be tens of megabytes, so they are streamed to a file in the
+// xdg cache dir instead of being held in memory and pushed through a single event.
+// the frontend receives a contexthandle and reads slices with readcontextrange; it
+// never holds a whole context. copying and saving a context happen here, and prompts
+// keep the {FILE_STRUCTURE} placeholder until they are copied, counted or sent, when
+// the backend puts the context in its place.
+
+// maxContextRangeBytes caps a single readcontextrange call.
+const maxContextRangeBytes = 4 * 1024 * 1024
+
+// storedContextsKept is how many generated contexts stay readable; older files are
+// deleted when a new one is committed.
+const storedContextsKept = 4
+
+var errUnknownContextHandle = errors.New("unknown or expired context handle")
+
+// contextPromptPlaceholder marks the place of the context in a prompt.
+const contextPromptPlaceholder = "{FILE_STRUCTURE}"
+
+// ContextHandle identifies a generated context stored on disk, together with the
+// metadata the frontend needs without reading the content.
+type ContextHandle struct {
+	Handle          string `json:"handle"`
+	RootDir         string `json:"rootDir"`
+	Format          string `json:"format"`
+	Size            int64  `json:"size"` // bytes
+	Lines           int    `json:"lines"`
+	EstimatedTokens int    `json:"estimatedTokens"`
+}
+
+// ContextRange is a slice of a stored context. next is the offset of the following
+// slice; it can differ from offset+length because slices never split a utf-8 sequence.
+type ContextRange struct {
+	Data   string `json:"data"`
+	Offset int64  `json:"offset"`
+	Next   int64  `json:"next"`
+	EOF    bool   `json:"eof"`
+}
+
+// contextwriter buffers writes to the output file and keeps the byte and line counts
+// used for the size limit and the handle metadata.
+type contextWriter struct {
+	w        *bufio.Writer
+	size     int64
+	lines    int
+	lastByte byte
+}
+
+func newContextWriter(w io.Writer) *contextWriter {
+	return &contextWriter{w: bufio.NewWriterSize(w, 256*1024)}
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.size += int64(n)
+	cw.lines += bytes.Count(p[:n], []byte{'\n'})
+	if n > 0 {
+		cw.lastByte = p[n-1]
+	}
+	return n, err
+}
+
+func (cw *contextWriter) Flush() error { return cw.w.Flush() }
+
+// contextstore owns the context files of the running app.
+type contextStore struct {
+	mu    sync.Mutex
+	dir   string
+	paths map[string]string // handle -> file path
+	order []string          // handles, oldest first
+}
+
+// newcontextstore prepares the directory of this process below the cache dir and
+// removes the directories left over by processes that have ended: handles never outlive
+// the process that created them. other shotgun windows keep their own directories.
+func newContextStore() (*contextStore, error) {
+	marker, err := xdg.CacheFile("shotgun-code/contexts/.keep")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve context cache dir: %w", err)
+	}
+	parent := filepath.Dir(marker)
+	if entries, err := os.ReadDir(parent); err == nil {
+		for _, entry := range entries {
+			pid, ok := strings.CutPrefix(entry.Name(), "run-")
+			if n, err := strconv.Atoi(pid); ok && err == nil && !processRunning(n) {
+				os.RemoveAll(filepath.Join(parent, entry.Name()))
+			}
+		}
+	}
+	s := &contextStore{dir: filepath.Join(parent, fmt.Sprintf("run-%d", os.Getpid())), paths: make(map[string]string)}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create context cache dir: %w", err)
+	}
+	return s, nil
+}
+
+// processRunning reports whether a process with the given id exists. when that cannot
+// be told, it is assumed to run, so its contexts are kept.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false // windows looks the process up here
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
+
+// create opens a new, not yet readable context file.
+func (s *contextStore) create() (string, *os.File, error) {
+	var raw [12]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", nil, err
+	}
+	handle := hex.EncodeToString(raw[:])
+	f, err := os.OpenFile(filepath.Join(s.dir, "context-"+handle+".txt"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create context file: %w", err)
+	}
+	return handle, f, nil
+}
+
+// commit makes a finished file readable under its handle and evicts the oldest files.
+func (s *contextStore) commit(handle, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths[handle] = path
+	s.order = append(s.order, handle)
+	for len(s.order) > storedContextsKept {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		os.Remove(s.paths[oldest])
+		delete(s.paths, oldest)
+	}
+}
+
+// release deletes the file behind handle; unknown handles are ignored.
+func (s *contextStore) release(handle string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.paths[handle]
+	if !ok {
+		return
+	}
+	os.Remove(p)
+	delete(s.paths, handle)
+	for i, h := range s.order {
+		if h == handle {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeall deletes every stored context and the directory of the process, used on
+// shutdown.
+func (s *contextStore) removeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.paths {
+		os.Remove(p)
+	}
+	os.Remove(s.dir) // fails while a generation still writes to it
+	s.paths = make(map[string]string)
+	s.order = nil
+}
+
+// open opens the file of a stored context.
+func (s *contextStore) open(handle string) (*os.File, error) {
+	s.mu.Lock()
+	p, ok := s.paths[handle]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errUnknownContextHandle
+	}
+	return os.Open(p)
+}
+
+// readAll returns a whole stored context.
+func (s *contextStore) readAll(handle string) (string, error) {
+	f, err := s.open(handle)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, f); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// readrange returns up to length bytes starting at offset, shortened so that it ends on
+// a utf-8 boundary. an offset inside a sequence is moved forward to the next rune.
+func (s *contextStore) readRange(handle string, offset, length int64) (ContextRange, error) {
+	if offset < 0 || length < 0 {
+		return ContextRange{}, fmt.Errorf("invalid range: offset %d, length %d", offset, length)
+	}
+	length = min(length, maxContextRangeBytes)
+
+	f, err := s.open(handle)
+	if err != nil {
+		return ContextRange{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ContextRange{}, err
+	}
+	size := info.Size()
+	if offset >= size {
+		return ContextRange{Offset: size, Next: size, EOF: true}, nil
+	}
+
+	// read utf8.utfmax-1 extra bytes so a rune cut by the requested end can be completed
+	buf := make([]byte, min(length+utf8.UTFMax-1, size-offset))
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return ContextRange{}, err
+	}
+	buf = buf[:n]
+
+	start := 0
+	for start < len(buf) && start < utf8.UTFMax-1 && !utf8.RuneStart(buf[start]) {
+		start++
+	}
+	end := min(int64(len(buf)), length)
+	if offset+end < size {
+		// extend to the end of a rune that straddles the boundary, or cut before it
+		for end < int64(len(buf)) && !utf8.RuneStart(buf[end]) {
+			end++
+		}
+	} else {
+		end = int64(len(buf))
+	}
+	if int64(start) > end {
+		start = int(end)
+	}
+	next := offset + end
+	return ContextRange{Data: string(buf[start:end]), Offset: offset + int64(start), Next: next, EOF: next >= size}, nil
+}
+
+// generatecontextfile streams a context into a new file in dir. the file is removed
+// again when generation fails, so callers only ever see complete contexts.
+func (a *App) generateContextFile(ctx context.Context, dir, pattern string, roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) (string, *ContextReport, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	report, err := a.writeContextTo(ctx, f, roots, excludedPaths, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", report, err
+	}
+	return f.Name(), report, nil
+}
+
+// writecontextto runs the generator into f and fills the size metadata of the report.
+func (a *App) writeContextTo(ctx context.Context, f io.Writer, roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) (*ContextReport, error) {
+	cw := newContextWriter(f)
+	report, err := a.generateShotgunOutputWithProgress(ctx, roots, excludedPaths, opts, cw)
+	if err != nil {
+		return report, err
+	}
+	if err := cw.Flush(); err != nil {
+		return report, fmt.Errorf("failed to write context: %w", err)
+	}
+	report.Size = cw.size
+	report.Lines = cw.lines
+	if cw.size > 0 && cw.lastByte != '\n' {
+		report.Lines++ // count the last line even without a trailing newline
+	}
+	report.EstimatedTokens = estimateTokens(int(cw.size))
+	return report, nil
+}
+
+// generatestoredcontext generates a context into the context store and returns its handle.
+func (a *App) generateStoredContext(ctx context.Context, roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) (ContextHandle, *ContextReport, error) {
+	if a.contextStore == nil {
+		return ContextHandle{}, nil, errors.New("context store is not available")
+	}
+	handle, f, err := a.contextStore.create()
+	if err != nil {
+		return ContextHandle{}, nil, err
+	}
+	report, err := a.writeContextTo(ctx, f, roots, excludedPaths, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return ContextHandle{}, report, err
+	}
+	a.contextStore.commit(handle, f.Name())
+	if report.snapshot != nil {
+		report.snapshot.ID = handle // the snapshot outlives the stored context
+		a.snapshots.put(report.snapshot)
+		report.Snapshot = handle
+	}
+	return ContextHandle{
+		Handle:          handle,
+		RootDir:         report.RootDir,
+		Format:          report.Format,
+		Size:            report.Size,
+		Lines:           report.Lines,
+		EstimatedTokens: report.EstimatedTokens,
+	}, report, nil
+}
+
+// readcontextrange is bound to wails: it returns a slice of a generated context, e.g.
+// the part the frontend shows. the next slice starts at next.
+func (a *App) ReadContextRange(handle string, offset int64, length int64) (ContextRange, error) {
+	if a.contextStore == nil {
+		return ContextRange{}, errUnknownContextHandle
+	}
+	return a.contextStore.readRange(handle, offset, length)
+}
+
+// releasecontext is bound to wails: it deletes a generated context the frontend no
+// longer needs. unknown handles are ignored.
+func (a *App) ReleaseContext(handle string) {
+	if a.contextStore != nil {
+		a.contextStore.release(handle)
+	}
+}
+
+// expandPrompt puts the context of handle in place of the placeholder in prompt. a
+// prompt without the placeholder, or without a handle, is returned as it is.
+func (a *App) expandPrompt(prompt, handle string) (string, error) {
+	if handle == "" || !strings.Contains(prompt, contextPromptPlaceholder) {
+		return prompt, nil
+	}
+	if a.contextStore == nil {
+		return "", errUnknownContextHandle
+	}
+	content, err := a.contextStore.readAll(handle)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(prompt, contextPromptPlaceholder, content, 1), nil
+}
+
+// CopyContext is bound to wails: it copies a generated context to the clipboard.
+func (a *App) CopyContext(handle string) error {
+	return a.CopyPrompt(contextPromptPlaceholder, handle)
+}
+
+// CopyPrompt is bound to wails: it copies prompt to the clipboard with the context of
+// handle in place of the placeholder.
+func (a *App) CopyPrompt(prompt, handle string) error {
+	text, err := a.expandPrompt(prompt, handle)
+	if err != nil {
+		return err
+	}
+	return runtime.ClipboardSetText(a.ctx, text)
+}
+
+// SaveContext is bound to wails: it asks for a file and copies a generated context
+// into it. it returns the path written, "" when the dialog is cancelled.
+func (a *App) SaveContext(handle string) (string, error) {
+	if a.contextStore == nil {
+		return "", errUnknownContextHandle
+	}
+	src, err := a.contextStore.open(handle)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	target, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{Title: "save context", DefaultFilename: "context.txt"})
+	if err != nil || target == "" {
+		return "", err
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", fmt.Errorf("failed to save context: %w", err)
+	}
+	return target, dst.Close()
+}
@@ -0,0 +1,47 @@
+package main
+
+import "crypto/sha256"
+
+// --- duplicate files ---
+
+// repositories often hold the same file more than once: vendored bundles, copied
+// fixtures, generated clients in two places. the body of such a file is written only
+// for its first occurrence in tree order; every later copy becomes a reference block,
+// <file path="b/x.go" same-as="a/x.go"/>. files are compared by the hash of their
+// inlined content (after transforms), so markers for omitted or binary files are never
+// merged. a copy that carries its own git diff is written in full.
+
+// contentHash identifies an inlined body; the zero value means "do not deduplicate".
+type contentHash [sha256.Size]byte
+
+// hashInlinedBody returns the hash of an inlined file body, zero for empty files (a
+// reference would not be shorter).
+func hashInlinedBody(content string) contentHash {
+	if content == "" {
+		return contentHash{}
+	}
+	return sha256.Sum256([]byte(content))
+}
+
+// duplicateTracker remembers the first path written for every body. a nil tracker
+// (deduplication turned off) never reports a duplicate.
+type duplicateTracker struct {
+	first map[contentHash]string
+}
+
+func newDuplicateTracker() *duplicateTracker {
+	return &duplicateTracker{first: make(map[contentHash]string)}
+}
+
+// check returns the path of an earlier file with the same body, or records path as the
+// first one with it.
+func (d *duplicateTracker) check(hash contentHash, path string) (string, bool) {
+	if d == nil || hash == (contentHash{}) {
+		return "", false
+	}
+	if original, ok := d.first[hash]; ok {
+		return original, true
+	}
+	d.first[hash] = path
+	return "", false
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- delta contexts ---
+
+// every generated context leaves a snapshot: the hash of each file block by path. a
+// context generated with ContextOptions.SinceSnapshot leaves out the tree and every
+// file whose block is unchanged since that snapshot. it starts with a header naming the
+// snapshot and the paths that are gone (deleted, or no longer selected), and marks
+// the blocks it does contain change="added" or change="modified". a model that saw the
+// earlier context can apply these updates instead of reading everything again.
+//
+// blocks are compared as they would be written (content after transforms, redaction and
+// line numbers, plus the diff), so changing those options marks every file modified.
+// the snapshot of a delta context still covers every file, so deltas can be chained.
+//
+// with a token budget, a delta is planned without a tree and only over the files whose
+// size or modification time changed since the snapshot (the others are very likely
+// unchanged and cost nothing). a changed file the budget leaves out is marked
+// change="omitted": the model keeps the version it saw, and so does the new snapshot,
+// so the next delta offers the file again.
+
+// storedSnapshotsKept bounds the snapshots kept in memory. the least recently used one
+// goes first, so a snapshot that deltas are generated against stays.
+const storedSnapshotsKept = 16
+
+// values of the change attribute of a block in a delta context.
+const (
+	deltaChangeAdded    = "added"
+	deltaChangeModified = "modified"
+	deltaChangeOmitted  = "omitted" // changed on disk, left out for the token budget
+)
+
+var errUnknownSnapshot = errors.New("unknown or expired context snapshot")
+
+// ContextSnapshot is what a generated context held.
+type ContextSnapshot struct {
+	ID        string            `json:"id"`
+	Workspace string            `json:"workspace"` // workspaceKey of the roots
+	RootDir   string            `json:"rootDir"`
+	Created   time.Time         `json:"created"`
+	Files     map[string]string `json:"files"` // block path -> hash of the block
+	// Options and Stats tell which files are unchanged on disk since the snapshot,
+	// see unchangedOnDisk
+	Options string            `json:"options,omitempty"`
+	Stats   map[string]string `json:"stats,omitempty"` // block path -> fileStat
+}
+
+// SnapshotInfo describes a stored snapshot without its file list.
+type SnapshotInfo struct {
+	ID      string    `json:"id"`
+	RootDir string    `json:"rootDir"`
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+}
+
+// DeltaReport lists the changes a delta context carries.
+type DeltaReport struct {
+	Since     string   `json:"since"`
+	Added     []string `json:"added"`
+	Modified  []string `json:"modified"`
+	Removed   []string `json:"removed"`
+	Omitted   []string `json:"omitted"`   // changed files left out for the token budget
+	Unchanged int      `json:"unchanged"` // files left out of the delta
+}
+
+// blockHash identifies the rendered block of a file. the git status is left out: it
+// changes with commits while the file stays the same.
+func blockHash(block contextFileBlock) string {
+	h := sha256.New()
+	for _, part := range []string{block.Content, block.Diff, block.SameAs} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	for _, attr := range block.Attrs {
+		if attr.Name != "git-status" {
+			fmt.Fprintf(h, "%s=%s;", attr.Name, attr.Value)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// snapshotFingerprint describes the options that shape the blocks of a context besides
+// what the read pool produces (contentCacheFingerprint).
+func snapshotFingerprint(opts ContextOptions) string {
+	return contentCacheFingerprint(opts) + fmt.Sprintf(" lines=%t diffs=%t base=%q dedup=%t", opts.LineNumbers, opts.IncludeDiffs, opts.BaseRef, !opts.DisableDedup)
+}
+
+// fileStat is the size and modification time of a file, "" when the time is unknown.
+func fileStat(f contextFileEntry) string {
+	if f.modTime.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(f.size, 10) + ":" + strconv.FormatInt(f.modTime.UnixNano(), 10)
+}
+
+// unchangedOnDisk reports whether f has the size and modification time it had when the
+// snapshot was taken with the same options, so its block is very likely unchanged. like
+// the content cache, it misses edits that keep both.
+func (s *ContextSnapshot) unchangedOnDisk(f contextFileEntry, fingerprint string) bool {
+	slashPath := filepath.ToSlash(f.relPath)
+	stat := fileStat(f)
+	return s.Options == fingerprint && stat != "" && s.Stats[slashPath] == stat && s.Files[slashPath] != ""
+}
+
+// removedSince returns the paths of base that are not among the files of a context,
+// sorted.
+func removedSince(base *ContextSnapshot, files []contextFileEntry) []string {
+	current := make(map[string]bool, len(files))
+	for _, f := range files {
+		current[filepath.ToSlash(f.relPath)] = true
+	}
+	removed := []string{}
+	for p := range base.Files {
+		if !current[p] {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// snapshotStore keeps the snapshots of recent contexts, least recently used first.
+type snapshotStore struct {
+	mu    sync.Mutex
+	byID  map[string]*ContextSnapshot
+	order []string
+}
+
+func newSnapshotStore() *snapshotStore {
+	return &snapshotStore{byID: make(map[string]*ContextSnapshot)}
+}
+
+// put stores snap under its id and evicts the least recently used snapshots. it is
+// nil-safe.
+func (s *snapshotStore) put(snap *ContextSnapshot) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(snap.ID)
+	s.byID[snap.ID] = snap
+	for len(s.order) > storedSnapshotsKept {
+		delete(s.byID, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get returns the snapshot with id and marks it as used. it is nil-safe.
+func (s *snapshotStore) get(id string) (*ContextSnapshot, error) {
+	if s == nil {
+		return nil, errUnknownSnapshot
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.byID[id]
+	if !ok {
+		return nil, errUnknownSnapshot
+	}
+	s.touch(id)
+	return snap, nil
+}
+
+// touch moves id to the end of the order; the caller holds mu.
+func (s *snapshotStore) touch(id string) {
+	for i, other := range s.order {
+		if other == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.order = append(s.order, id)
+}
+
+// list returns the stored snapshots, newest first.
+func (s *snapshotStore) list() []SnapshotInfo {
+	if s == nil {
+		return []SnapshotInfo{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]SnapshotInfo, 0, len(s.byID))
+	for _, snap := range s.byID {
+		infos = append(infos, SnapshotInfo{ID: snap.ID, RootDir: snap.RootDir, Created: snap.Created, Files: len(snap.Files)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.After(infos[j].Created) })
+	return infos
+}
+
+// ListContextSnapshots returns the snapshots a delta context can be generated against,
+// newest first. a snapshot has the id of the context handle it was taken from.
+func (a *App) ListContextSnapshots() []SnapshotInfo {
+	return a.snapshots.list()
+}
+
+// readSnapshotFile loads a snapshot written by writeSnapshotFile (the cli's -snapshot).
+func readSnapshotFile(path string) (*ContextSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap ContextSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if snap.Files == nil {
+		return nil, fmt.Errorf("invalid snapshot %s: no file list", path)
+	}
+	return &snap, nil
+}
+
+func writeSnapshotFile(path string, snap *ContextSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	textunicode "golang.org/x/text/encoding/unicode"
+)
+
+// --- legacy text encodings ---
+
+// files that are not valid utf-8 are not necessarily binary: older projects keep
+// windows-1251 or shift_jis sources and windows tools like to write utf-16. such files
+// are decoded to utf-8 for the context and their <file> block records the original
+// encoding. detection only runs for files isTextContent rejects, so utf-8 files are
+// never touched.
+
+// names used in the encoding attribute.
+const (
+	encodingUTF16LE     = "utf-16le"
+	encodingUTF16BE     = "utf-16be"
+	encodingWindows1252 = "windows-1252"
+	encodingLatin1      = "iso-8859-1"
+	encodingWindows1251 = "windows-1251"
+	encodingKOI8R       = "koi8-r"
+	encodingShiftJIS    = "shift_jis"
+	encodingEUCJP       = "euc-jp"
+	encodingGB18030     = "gb18030"
+	encodingBig5        = "big5"
+	encodingEUCKR       = "euc-kr"
+)
+
+// minEncodingScore is how plausible a decoding must look (see scoreDecoding) before a
+// file is treated as text in that encoding.
+const minEncodingScore = 0.6
+
+// legacyEncodingSample bounds how much of a file the heuristics look at.
+const legacyEncodingSample = 64 * 1024
+
+// legacyCandidate is an encoding tried by the heuristics; on equal scores the earlier
+// candidate wins, so the more distinctive scripts come first.
+type legacyCandidate struct {
+	name   string
+	enc    encoding.Encoding
+	script legacyScript
+}
+
+type legacyScript int
+
+const (
+	scriptKorean legacyScript = iota
+	scriptJapanese
+	scriptSimplifiedChinese
+	scriptTraditionalChinese
+	scriptCyrillic
+	scriptLatin
+)
+
+var legacyCandidates = []legacyCandidate{
+	{encodingEUCKR, korean.EUCKR, scriptKorean},
+	{encodingShiftJIS, japanese.ShiftJIS, scriptJapanese},
+	{encodingEUCJP, japanese.EUCJP, scriptJapanese},
+	{encodingGB18030, simplifiedchinese.GB18030, scriptSimplifiedChinese},
+	{encodingBig5, traditionalchinese.Big5, scriptTraditionalChinese},
+	{encodingWindows1251, charmap.Windows1251, scriptCyrillic},
+	{encodingKOI8R, charmap.KOI8R, scriptCyrillic},
+	{encodingWindows1252, charmap.Windows1252, scriptLatin},
+}
+
+// the most frequent characters of chinese text (see encoding_han.go). random byte
+// pairs decoded as chinese are valid but rarely common, which tells real gb18030 and
+// big5 text apart.
+var commonHanSets = map[legacyScript]map[rune]bool{
+	scriptSimplifiedChinese:  runeSet(commonSimplifiedHan),
+	scriptTraditionalChinese: runeSet(commonTraditionalHan),
+}
+
+func runeSet(runes []rune) map[rune]bool {
+	set := make(map[rune]bool, len(runes))
+	for _, r := range runes {
+		set[r] = true
+	}
+	return set
+}
+
+// decodeLegacyText tries to read data, which is not valid utf-8, as text in another
+// encoding. it returns the utf-8 text and the encoding name, or ok=false when the data
+// does not look like text in any supported encoding.
+func decodeLegacyText(data []byte) (text, encodingName string, ok bool) {
+	if name, enc := detectUTF16(data); enc != nil {
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil || !isTextContent(decoded) {
+			return "", "", false
+		}
+		return string(decoded), name, true
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return "", "", false // nul bytes outside utf-16 mean binary data
+	}
+
+	sample := data
+	if len(sample) > legacyEncodingSample {
+		sample = sample[:legacyEncodingSample]
+		// do not cut a multi-byte character: back off to the last ascii byte
+		if cut := bytes.LastIndexAny(sample, "\n\r\t "); cut > 0 {
+			sample = sample[:cut]
+		}
+	}
+	best, bestScore := -1, minEncodingScore
+	for i, c := range legacyCandidates {
+		decoded, err := c.enc.NewDecoder().Bytes(sample)
+		if err != nil {
+			continue
+		}
+		if score := scoreDecoding(string(decoded), c.script); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return "", "", false
+	}
+	c := legacyCandidates[best]
+	decoded, err := c.enc.NewDecoder().Bytes(data)
+	if err != nil || !isTextContent(decoded) {
+		return "", "", false
+	}
+	name := c.name
+	if c.script == scriptLatin && !hasC1Bytes(data) {
+		name = encodingLatin1 // windows-1252 only differs from latin-1 in 0x80-0x9f
+	}
+	return string(decoded), name, true
+}
+
+// detectUTF16 recognizes utf-16 by its byte order mark or, without one, by the nul
+// bytes that ascii characters leave in every other byte.
+func detectUTF16(data []byte) (string, encoding.Encoding) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return encodingUTF16LE, textunicode.UTF16(textunicode.LittleEndian, textunicode.ExpectBOM)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return encodingUTF16BE, textunicode.UTF16(textunicode.BigEndian, textunicode.ExpectBOM)
+	}
+	n := min(len(data), 4096) &^ 1
+	if n < 4 {
+		return "", nil
+	}
+	var evenNul, oddNul int
+	for i := 0; i < n; i += 2 {
+		if data[i] == 0 {
+			evenNul++
+		}
+		if data[i+1] == 0 {
+			oddNul++
+		}
+	}
+	pairs := n / 2
+	switch {
+	case oddNul*10 >= pairs*4 && evenNul*20 < pairs:
+		return encodingUTF16LE, textunicode.UTF16(textunicode.LittleEndian, textunicode.IgnoreBOM)
+	case evenNul*10 >= pairs*4 && oddNul*20 < pairs:
+		return encodingUTF16BE, textunicode.UTF16(textunicode.BigEndian, textunicode.IgnoreBOM)
+	}
+	return "", nil
+}
+
+// scoreDecoding rates in [0, 1] how much decoded looks like real text in script. only
+// non-ascii characters count: a wrong code page still decodes them, but to letters of
+// the wrong script, to replacement characters, or to random rare characters.
+func scoreDecoding(decoded string, script legacyScript) float64 {
+	var nonASCII, expected, common, kana, lower, letters int
+	var runs, runLength, longRuns int // runs of consecutive non-ascii characters
+	endRun := func() {
+		if runLength > 0 {
+			runs++
+			if runLength >= 2 {
+				longRuns += runLength
+			}
+		}
+		runLength = 0
+	}
+	commonSet := commonHanSets[script]
+	for _, r := range decoded {
+		if r < utf8.RuneSelf {
+			endRun()
+			continue
+		}
+		nonASCII++
+		runLength++
+		if r == utf8.RuneError {
+			return 0
+		}
+		isHan := unicode.Is(unicode.Han, r)
+		isKana := unicode.In(r, unicode.Hiragana, unicode.Katakana) && (r < 0xFF65 || r > 0xFF9F) // not half-width
+		isCJKPunct := (r >= 0x3000 && r <= 0x303F) || (r >= 0xFF01 && r <= 0xFF5E)
+		switch script {
+		case scriptKorean:
+			if unicode.Is(unicode.Hangul, r) || isCJKPunct {
+				expected++
+			}
+		case scriptJapanese:
+			if isKana {
+				kana++
+			}
+			if isKana || isHan || isCJKPunct {
+				expected++
+			}
+		case scriptSimplifiedChinese, scriptTraditionalChinese:
+			if isHan || isCJKPunct {
+				expected++
+			}
+			if commonSet[r] {
+				common++
+			}
+		case scriptCyrillic:
+			if unicode.Is(unicode.Cyrillic, r) && unicode.IsLetter(r) {
+				expected++
+				letters++
+				if unicode.IsLower(r) {
+					lower++
+				}
+			}
+		case scriptLatin:
+			if unicode.Is(unicode.Latin, r) || unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) {
+				expected++
+			}
+		}
+	}
+	endRun()
+	if nonASCII == 0 {
+		return 0
+	}
+	score := float64(expected) / float64(nonASCII)
+	switch script {
+	case scriptJapanese:
+		if kana*10 < nonASCII { // japanese prose without any kana is unlikely
+			score *= 0.5
+		}
+	case scriptSimplifiedChinese, scriptTraditionalChinese:
+		// real chinese text uses its most common characters a lot; a misdecoded file
+		// yields valid but mostly rare characters
+		score *= 0.5 + 0.5*min(1, 4*float64(common)/float64(max(expected, 1)))
+	case scriptCyrillic:
+		// cyrillic words are runs of letters, mostly lower case; accented letters in
+		// latin text are isolated
+		score *= float64(longRuns) / float64(nonASCII)
+		if letters > 0 {
+			score *= 0.5 + 0.5*float64(lower)/float64(letters)
+		}
+	case scriptLatin:
+		isolated := float64(nonASCII-longRuns) / float64(nonASCII)
+		score *= 0.4 + 0.6*isolated
+	}
+	return score
+}
+
+// hasC1Bytes reports whether data uses 0x80-0x9f, where windows-1252 has characters
+// and latin-1 only control codes.
+func hasC1Bytes(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- excerpts of oversized files ---
+
+// files over maxFileReadSizeBytes are not inlined whole. instead their block holds the
+// first and last lines, the total line count and, for go sources, the top-level
+// declarations, so the model still sees what the file is. the head and tail come from
+// a single streamed pass that keeps only the excerpt in memory; a go file up to
+// maxOutlineParseBytes is read whole a second time to be parsed for its declarations.
+
+// defaultExcerptLines is used when contextoptions.excerptlines is 0.
+const defaultExcerptLines = 200
+
+// maxExcerptLineBytes cuts very long lines (minified or generated files) in excerpts.
+const maxExcerptLineBytes = 1000
+
+// go files up to this size are parsed for their declarations; larger ones only get
+// the head and tail. the source and its ast are held in memory while parsing, possibly
+// on every read worker at once, so the cap stays a small multiple of the inline limit.
+const maxOutlineParseBytes = 4 * maxFileReadSizeBytes
+
+// maxExcerptDecls bounds the declaration list of a generated go file.
+const maxExcerptDecls = 500
+
+// omitReasonExcerpt is reported by the packing plan for oversized files that are
+// excerpted rather than left out.
+const omitReasonExcerpt = "excerpt"
+
+// ExcerptedFile is an oversized file that was inlined partially.
+type ExcerptedFile struct {
+	Path  string `json:"path"` // forward slashes, as in the <file> blocks
+	Size  int64  `json:"size"`
+	Lines int    `json:"lines"`
+	Shown string `json:"shown"` // line ranges in the block, e.g. "1-200,9801-10000"
+}
+
+// excerptLineCount returns the number of lines kept at each end, 0 when oversized files
+// are left out entirely.
+func excerptLineCount(opts ContextOptions) int {
+	switch {
+	case opts.ExcerptLines < 0:
+		return 0
+	case opts.ExcerptLines == 0:
+		return defaultExcerptLines
+	}
+	return opts.ExcerptLines
+}
+
+// estimatedExcerptBytes is what the packing plan reserves for the excerpt of a file of
+// size bytes: both ends at a typical line length plus room for the markers.
+func estimatedExcerptBytes(size int64, lines int) int {
+	return int(min(size, int64(2*lines*80+2048)))
+}
+
+// fileExcerpt is the streamed head and tail of a file.
+type fileExcerpt struct {
+	totalLines int
+	head       []string
+	tail       []string // ring buffer of the last lines; tailNext is the oldest entry
+	tailNext   int
+}
+
+// errExcerptNotText is returned for oversized files that do not start with utf-8 text.
+var errExcerptNotText = errors.New("not text")
+
+// readExcerpt streams the file and keeps its first and last n lines.
+func readExcerpt(file contextFileEntry, n int) (*fileExcerpt, error) {
+	f, err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	sample, _ := r.Peek(8 * 1024)
+	if !isTextContent(trimPartialRune(sample)) {
+		return nil, errExcerptNotText
+	}
+
+	e := &fileExcerpt{}
+	for {
+		line, err := readExcerptLine(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.totalLines++
+		switch {
+		case len(e.head) < n:
+			e.head = append(e.head, line)
+		case len(e.tail) < n:
+			e.tail = append(e.tail, line)
+		default:
+			e.tail[e.tailNext] = line
+			e.tailNext = (e.tailNext + 1) % n
+		}
+	}
+	e.tail = append(e.tail[e.tailNext:], e.tail[:e.tailNext]...)
+	return e, nil
+}
+
+// readExcerptLine returns the next line without its line ending, cut to
+// maxExcerptLineBytes. io.EOF is only returned when there is no line left.
+func readExcerptLine(r *bufio.Reader) (string, error) {
+	var kept []byte
+	total := 0
+	for {
+		chunk, err := r.ReadSlice('\n')
+		total += len(chunk)
+		if room := maxExcerptLineBytes - len(kept); room > 0 {
+			kept = append(kept, chunk[:min(room, len(chunk))]...)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && total == 0:
+			return "", io.EOF
+		case err != nil && err != io.EOF:
+			return "", err
+		}
+		line := strings.TrimRight(string(kept), "\r\n")
+		if total > maxExcerptLineBytes+2 {
+			line = string(trimPartialRune([]byte(line))) + fmt.Sprintf(" … [line cut, %d bytes]", total)
+		}
+		return line, nil
+	}
+}
+
+// trimPartialRune drops an incomplete utf-8 sequence at the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// goTopLevelDecls lists the top-level declarations of a go file as "line: decl".
+func goTopLevelDecls(f contextFileEntry) []string {
+	src, err := f.readFile()
+	if err != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, f.absPath, src, parser.SkipObjectResolution) // a partial ast is still useful
+	if file == nil {
+		return nil
+	}
+	var decls []string
+	add := func(pos token.Pos, decl string) {
+		decls = append(decls, strconv.Itoa(fset.Position(pos).Line)+": "+decl)
+	}
+	for _, d := range file.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				name = "(" + types.ExprString(d.Recv.List[0].Type) + ") " + name
+			}
+			add(d.Pos(), "func "+name)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Pos(), "type "+s.Name.Name)
+				case *ast.ValueSpec:
+					names := make([]string, len(s.Names))
+					for i, ident := range s.Names {
+						names[i] = ident.Name
+					}
+					add(s.Pos(), d.Tok.String()+" "+strings.Join(names, ", "))
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// loadExcerpt builds the block body for an oversized file. it returns ok=false when the
+// file is not text, so the caller falls back to the too-large marker.
+func (a *App) loadExcerpt(f contextFileEntry, n int) (body string, attrs []fileAttr, info *ExcerptedFile, ok bool) {
+	e, err := readExcerpt(f, n)
+	if err != nil {
+		if !errors.Is(err, errExcerptNotText) && !errors.Is(err, errVirtualContentUnavailable) {
+			a.logWarningf("excerpt: error reading file %s: %v", f.absPath, err)
+		}
+		return "", nil, nil, false
+	}
+
+	tailStart := e.totalLines - len(e.tail) + 1
+	ranges := []string{fmt.Sprintf("%d-%d", 1, len(e.head))}
+	if len(e.tail) > 0 {
+		if tailStart == len(e.head)+1 {
+			ranges = []string{fmt.Sprintf("%d-%d", 1, e.totalLines)}
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", tailStart, e.totalLines))
+		}
+	}
+	if e.totalLines == 0 {
+		ranges = nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[excerpt of a large file: %d bytes, %d lines; showing lines %s]\n", f.size, e.totalLines, strings.Join(ranges, " and "))
+	if strings.EqualFold(filepath.Ext(f.absPath), ".go") && f.size <= maxOutlineParseBytes {
+		if decls := goTopLevelDecls(f); len(decls) > 0 {
+			fmt.Fprintf(&sb, "[top-level declarations: %d]\n", len(decls))
+			for i, decl := range decls {
+				if i == maxExcerptDecls {
+					fmt.Fprintf(&sb, "[... %d more declarations]\n", len(decls)-maxExcerptDecls)
+					break
+				}
+				sb.WriteString(decl + "\n")
+			}
+		}
+	}
+	if len(e.head) > 0 {
+		fmt.Fprintf(&sb, "[lines 1-%d]\n", len(e.head))
+		for _, line := range e.head {
+			sb.WriteString(line + "\n")
+		}
+	}
+	if len(e.tail) > 0 {
+		if tailStart > len(e.head)+1 {
+			fmt.Fprintf(&sb, "[lines %d-%d omitted]\n", len(e.head)+1, tailStart-1)
+		}
+		fmt.Fprintf(&sb, "[lines %d-%d]\n", tailStart, e.totalLines)
+		for _, line := range e.tail {
+			sb.WriteString(line + "\n")
+		}
+	}
+
+	shown := strings.Join(ranges, ",")
+	attrs = []fileAttr{{Name: "lines", Value: strconv.Itoa(e.totalLines)}, {Name: "excerpt", Value: shown}}
+	info = &ExcerptedFile{Path: filepath.ToSlash(f.relPath), Size: f.size, Lines: e.totalLines, Shown: shown}
+	return sb.String(), attrs, info, true
+}
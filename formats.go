@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// --- context output formats ---
+
+// supported values for contextoptions.format. the empty string means xml.
+const (
+	contextFormatXML      = "xml"
+	contextFormatMarkdown = "markdown"
+	contextFormatJSON     = "json"
+)
+
+// fileAttr is an extra attribute of a file block (e.g. the original encoding). formats
+// render them in their own way: xml attributes, json fields, markdown heading notes.
+type fileAttr struct {
+	Name  string
+	Value string
+}
+
+// contextfileblock is everything a formatter needs to render one file.
+type contextFileBlock struct {
+	Path     string // relative, forward slashes
+	Language string // markdown fence / json language tag, may be empty
+	Content  string
+	Diff     string // git diff of the file, rendered after the content when not empty
+	Attrs    []fileAttr
+	SameAs   string // set for a reference to an identical file written earlier, see dedup.go
+}
+
+// contextformatter renders the tree and the file blocks of a context. writefile is
+// called once per file in tree order; finish is called once after the last file.
+type contextFormatter interface {
+	writeTree(w io.Writer, tree string) error
+	// writeDelta replaces the tree in a delta context (see delta.go): it names the
+	// snapshot and lists the removed paths.
+	writeDelta(w io.Writer, since string, removed []string) error
+	writeFile(w io.Writer, block contextFileBlock) error
+	finish(w io.Writer) error
+	// name is the canonical format name reported back to the caller.
+	name() string
+	// blockoverhead is the number of bytes the format adds around the content of
+	// block; it is used to estimate sizes before any file is read.
+	blockOverhead(block contextFileBlock) int
+}
+
+// newcontextformatter returns a fresh formatter for the requested format name.
+func newContextFormatter(format string) (contextFormatter, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", contextFormatXML:
+		return &xmlContextFormatter{}, nil
+	case contextFormatMarkdown, "md":
+		return &markdownContextFormatter{}, nil
+	case contextFormatJSON:
+		return &jsonContextFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown context format %q (supported: xml, markdown, json)", format)
+	}
+}
+
+// xmlcontextformatter produces the classic output: the tree, an empty line, then
+// <file path="..."> blocks separated by newlines.
+type xmlContextFormatter struct {
+	wroteFile bool
+}
+
+var xmlAttrEscaper = strings.NewReplacer(`&`, "&amp;", `"`, "&quot;", `<`, "&lt;")
+
+func (f *xmlContextFormatter) openTag(block contextFileBlock) string {
+	var sb strings.Builder
+	sb.WriteString(`<file path="` + xmlAttrEscaper.Replace(block.Path) + `"`)
+	if block.SameAs != "" {
+		sb.WriteString(` same-as="` + xmlAttrEscaper.Replace(block.SameAs) + `"`)
+	}
+	for _, attr := range block.Attrs {
+		sb.WriteString(" " + attr.Name + `="` + xmlAttrEscaper.Replace(attr.Value) + `"`)
+	}
+	if block.SameAs != "" {
+		sb.WriteString("/>") // a reference has no body
+		return sb.String()
+	}
+	sb.WriteString(">\n")
+	return sb.String()
+}
+
+func (f *xmlContextFormatter) writeTree(w io.Writer, tree string) error {
+	_, err := io.WriteString(w, tree+"\n")
+	return err
+}
+
+func (f *xmlContextFormatter) writeDelta(w io.Writer, since string, removed []string) error {
+	var sb strings.Builder
+	sb.WriteString(`<delta since="` + xmlAttrEscaper.Replace(since) + `">` + "\n")
+	for _, p := range removed {
+		sb.WriteString(`<removed path="` + xmlAttrEscaper.Replace(p) + `"/>` + "\n")
+	}
+	sb.WriteString("</delta>\n\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (f *xmlContextFormatter) writeFile(w io.Writer, block contextFileBlock) error {
+	sep := ""
+	if f.wroteFile {
+		sep = "\n"
+	}
+	f.wroteFile = true
+	if block.SameAs != "" {
+		_, err := io.WriteString(w, sep+f.openTag(block))
+		return err
+	}
+	diff := ""
+	if block.Diff != "" {
+		diff = "\n<git-diff>\n" + strings.TrimRight(block.Diff, "\n") + "\n</git-diff>"
+	}
+	_, err := io.WriteString(w, sep+f.openTag(block)+block.Content+diff+"\n</file>")
+	return err
+}
+
+func (f *xmlContextFormatter) finish(io.Writer) error { return nil }
+
+func (f *xmlContextFormatter) name() string { return contextFormatXML }
+
+func (f *xmlContextFormatter) blockOverhead(block contextFileBlock) int {
+	return len(f.openTag(block)) + len("\n</file>\n")
+}
+
+// markdowncontextformatter renders the tree and every file as fenced code blocks,
+// which some models follow more reliably than xml tags.
+type markdownContextFormatter struct{}
+
+// markdownfence returns a backtick fence longer than any backtick run in content.
+func markdownFence(content string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(content); i++ {
+		if content[i] == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+func (f *markdownContextFormatter) heading(block contextFileBlock) string {
+	heading := "## " + block.Path
+	if block.SameAs != "" {
+		heading += " (same-as: " + block.SameAs + ")"
+	}
+	for _, attr := range block.Attrs {
+		heading += fmt.Sprintf(" (%s: %s)", attr.Name, attr.Value)
+	}
+	return heading + "\n\n"
+}
+
+func (f *markdownContextFormatter) writeTree(w io.Writer, tree string) error {
+	fence := markdownFence(tree)
+	_, err := io.WriteString(w, "# project tree\n\n"+fence+"text\n"+strings.TrimRight(tree, "\n")+"\n"+fence+"\n")
+	return err
+}
+
+func (f *markdownContextFormatter) writeDelta(w io.Writer, since string, removed []string) error {
+	var sb strings.Builder
+	sb.WriteString("# changes since context " + since + "\n\nonly files added or modified since then follow; all other files are unchanged. files marked omitted may have changed but were left out to fit the token budget.\n")
+	if len(removed) > 0 {
+		sb.WriteString("\nremoved:\n\n")
+		for _, p := range removed {
+			sb.WriteString("- " + p + "\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (f *markdownContextFormatter) writeFile(w io.Writer, block contextFileBlock) error {
+	if block.SameAs != "" {
+		_, err := io.WriteString(w, "\n"+f.heading(block))
+		return err
+	}
+	fence := markdownFence(block.Content)
+	content := block.Content
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	_, err := io.WriteString(w, "\n"+f.heading(block)+fence+block.Language+"\n"+content+fence+"\n")
+	if err != nil || block.Diff == "" {
+		return err
+	}
+	diff := strings.TrimRight(block.Diff, "\n") + "\n"
+	diffFence := markdownFence(diff)
+	_, err = io.WriteString(w, "\n"+diffFence+"diff\n"+diff+diffFence+"\n")
+	return err
+}
+
+func (f *markdownContextFormatter) finish(io.Writer) error { return nil }
+
+func (f *markdownContextFormatter) name() string { return contextFormatMarkdown }
+
+func (f *markdownContextFormatter) blockOverhead(block contextFileBlock) int {
+	return len(f.heading(block)) + 2*len("```") + len(block.Language) + 4
+}
+
+// jsoncontextformatter writes {"tree": ..., "files": [{"path", "language", "content", "diff"}]}.
+// the document is written incrementally so large contexts never need a second copy.
+type jsonContextFormatter struct {
+	wroteFile bool
+}
+
+type jsonContextFile struct {
+	Path       string            `json:"path"`
+	Language   string            `json:"language"`
+	Content    string            `json:"content"`
+	Diff       string            `json:"diff,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	SameAs     string            `json:"sameAs,omitempty"` // content is empty, see dedup.go
+}
+
+// marshaljson encodes v without html escaping so code stays readable (<, >, &).
+func marshalJSON(v interface{}) ([]byte, error) {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(sb.String(), "\n")), nil
+}
+
+func (f *jsonContextFormatter) writeTree(w io.Writer, tree string) error {
+	data, err := marshalJSON(tree)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "{\n  \"tree\": "+string(data)+",\n  \"files\": [")
+	return err
+}
+
+func (f *jsonContextFormatter) writeDelta(w io.Writer, since string, removed []string) error {
+	data, err := marshalJSON(struct {
+		Since   string   `json:"since"`
+		Removed []string `json:"removed"`
+	}{since, removed})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "{\n  \"delta\": "+string(data)+",\n  \"files\": [")
+	return err
+}
+
+func (f *jsonContextFormatter) entry(block contextFileBlock) jsonContextFile {
+	entry := jsonContextFile{Path: block.Path, Language: block.Language, Content: block.Content, Diff: block.Diff, SameAs: block.SameAs}
+	if len(block.Attrs) > 0 {
+		entry.Attributes = make(map[string]string, len(block.Attrs))
+		for _, attr := range block.Attrs {
+			entry.Attributes[attr.Name] = attr.Value
+		}
+	}
+	return entry
+}
+
+func (f *jsonContextFormatter) writeFile(w io.Writer, block contextFileBlock) error {
+	data, err := marshalJSON(f.entry(block))
+	if err != nil {
+		return err
+	}
+	sep := "\n    "
+	if f.wroteFile {
+		sep = ",\n    "
+	}
+	f.wroteFile = true
+	_, err = io.WriteString(w, sep+string(data))
+	return err
+}
+
+func (f *jsonContextFormatter) finish(w io.Writer) error {
+	closing := "]\n}\n"
+	if f.wroteFile {
+		closing = "\n  ]\n}\n"
+	}
+	_, err := io.WriteString(w, closing)
+	return err
+}
+
+func (f *jsonContextFormatter) name() string { return contextFormatJSON }
+
+func (f *jsonContextFormatter) blockOverhead(block contextFileBlock) int {
+	data, err := marshalJSON(f.entry(block))
+	if err != nil {
+		return 0
+	}
+	return len(data) + len(",\n    ")
+}
+
+// languagebyextension maps file extensions to markdown/json language tags.
+var languageByExtension = map[string]string{
+	".go": "go", ".mod": "go-mod", ".sum": "text",
+	".js": "javascript", ".mjs": "javascript", ".cjs": "javascript", ".jsx": "jsx",
+	".ts": "typescript", ".tsx": "tsx", ".vue": "vue", ".svelte": "svelte",
+	".py": "python", ".rb": "ruby", ".php": "php", ".java": "java", ".kt": "kotlin",
+	".kts": "kotlin", ".scala": "scala", ".swift": "swift", ".rs": "rust",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".cxx": "cpp", ".hpp": "cpp",
+	".cs": "csharp", ".fs": "fsharp", ".m": "objectivec", ".dart": "dart",
+	".lua": "lua", ".pl": "perl", ".r": "r", ".jl": "julia", ".ex": "elixir",
+	".exs": "elixir", ".erl": "erlang", ".hs": "haskell", ".clj": "clojure",
+	".sh": "bash", ".bash": "bash", ".zsh": "zsh", ".fish": "fish", ".ps1": "powershell",
+	".bat": "batch", ".cmd": "batch",
+	".html": "html", ".htm": "html", ".xml": "xml", ".svg": "xml", ".css": "css",
+	".scss": "scss", ".sass": "sass", ".less": "less",
+	".json": "json", ".jsonc": "jsonc", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml",
+	".ini": "ini", ".cfg": "ini", ".conf": "ini", ".env": "dotenv",
+	".md": "markdown", ".markdown": "markdown", ".rst": "rst", ".txt": "text",
+	".sql": "sql", ".graphql": "graphql", ".gql": "graphql", ".proto": "protobuf",
+	".tf": "hcl", ".hcl": "hcl", ".dockerfile": "dockerfile", ".gradle": "groovy",
+	".groovy": "groovy", ".diff": "diff", ".patch": "diff", ".ipynb": "json",
+}
+
+// languagebyfilename covers well-known files without a meaningful extension.
+var languageByFilename = map[string]string{
+	"dockerfile": "dockerfile", "makefile": "makefile", "gnumakefile": "makefile",
+	"cmakelists.txt": "cmake", "go.mod": "go-mod", "go.sum": "text",
+	".gitignore": "gitignore", ".dockerignore": "gitignore", ".env": "dotenv",
+}
+
+// languageforpath guesses the language tag of a file from its name.
+func languageForPath(relPath string) string {
+	base := strings.ToLower(path.Base(relPath))
+	if lang, ok := languageByFilename[base]; ok {
+		return lang
+	}
+	if strings.HasPrefix(base, ".env.") {
+		return "dotenv"
+	}
+	return languageByExtension[path.Ext(base)]
+}
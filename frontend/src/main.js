@@ -19,9 +19,9 @@ function registerGlobalShotgunListeners() {
   unregisterGlobalShotgunListeners();
 
   try {
-    globalListeners.shotgunContextGenerated = EventsOn("shotgunContextGenerated", (output) => {
+    globalListeners.shotgunContextGenerated = EventsOn("shotgunContextGenerated", (handle) => {
       window.dispatchEvent(
-        new CustomEvent("shotgun-context-generated", { detail: output })
+        new CustomEvent("shotgun-context-generated", { detail: handle })
       );
     });
 
@@ -3,9 +3,13 @@
 import {main} from '../models';
 import {context} from '../models';
 
-export function CountGeminiTokens(arg1:string):Promise<number>;
+export function CopyContext(arg1:string):Promise<void>;
 
-export function ExecuteGeminiRequest(arg1:string,arg2:string):Promise<string>;
+export function CopyPrompt(arg1:string,arg2:string):Promise<void>;
+
+export function CountGeminiTokens(arg1:string,arg2:string):Promise<number>;
+
+export function ExecuteGeminiRequest(arg1:string,arg2:string,arg3:string):Promise<string>;
 
 export function GetCustomIgnoreRules():Promise<string>;
 
@@ -13,20 +17,48 @@ export function GetCustomPromptRules():Promise<string>;
 
 export function GetGeminiAPIKey():Promise<string>;
 
+export function GetHardExcludedDirs(arg1:string):Promise<main.HardExclusions>;
+
+export function GetSymlinkPolicy():Promise<string>;
+
+export function ListContextSnapshots():Promise<Array<main.SnapshotInfo>>;
+
 export function ListFiles(arg1:string):Promise<Array<main.FileNode>>;
 
+export function ListWorkspaceFiles(arg1:Array<main.WorkspaceRoot>):Promise<Array<main.FileNode>>;
+
+export function ReadContextRange(arg1:string,arg2:number,arg3:number):Promise<main.ContextRange>;
+
+export function ReleaseContext(arg1:string):Promise<void>;
+
 export function RequestShotgunContextGeneration(arg1:string,arg2:Array<string>):Promise<void>;
 
+export function RequestShotgunContextGenerationWithOptions(arg1:string,arg2:Array<string>,arg3:main.ContextOptions):Promise<void>;
+
+export function RequestWorkspaceContextGeneration(arg1:Array<main.WorkspaceRoot>,arg2:Array<string>,arg3:main.ContextOptions):Promise<void>;
+
 export function ResetApplication():Promise<void>;
 
+export function ResetHardExcludedDirs(arg1:string):Promise<void>;
+
+export function SaveContext(arg1:string):Promise<string>;
+
+export function SelectArchive():Promise<string>;
+
 export function SelectDirectory():Promise<string>;
 
+export function SelectGoPackageDependencies(arg1:Array<main.WorkspaceRoot>,arg2:Array<string>,arg3:main.GoDependencyOptions):Promise<main.GoDependencySelection>;
+
 export function SetCustomIgnoreRules(arg1:string):Promise<void>;
 
 export function SetCustomPromptRules(arg1:string):Promise<void>;
 
 export function SetGeminiAPIKey(arg1:string):Promise<void>;
 
+export function SetHardExcludedDirs(arg1:string,arg2:Array<string>):Promise<void>;
+
+export function SetSymlinkPolicy(arg1:string):Promise<void>;
+
 export function SetUseCustomIgnore(arg1:boolean):Promise<void>;
 
 export function SetUseGitignore(arg1:boolean):Promise<void>;
@@ -35,6 +67,8 @@ export function SplitShotgunDiff(arg1:string,arg2:number):Promise<Array<string>>
 
 export function StartFileWatcher(arg1:string):Promise<void>;
 
+export function StartWorkspaceWatcher(arg1:Array<main.WorkspaceRoot>):Promise<void>;
+
 export function StartupTest(arg1:context.Context):Promise<void>;
 
 export function StopFileWatcher():Promise<void>;
@@ -2,12 +2,20 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
-export function CountGeminiTokens(arg1) {
-  return window['go']['main']['App']['CountGeminiTokens'](arg1);
+export function CopyContext(arg1) {
+  return window['go']['main']['App']['CopyContext'](arg1);
 }
 
-export function ExecuteGeminiRequest(arg1, arg2) {
-  return window['go']['main']['App']['ExecuteGeminiRequest'](arg1, arg2);
+export function CopyPrompt(arg1, arg2) {
+  return window['go']['main']['App']['CopyPrompt'](arg1, arg2);
+}
+
+export function CountGeminiTokens(arg1, arg2) {
+  return window['go']['main']['App']['CountGeminiTokens'](arg1, arg2);
+}
+
+export function ExecuteGeminiRequest(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ExecuteGeminiRequest'](arg1, arg2, arg3);
 }
 
 export function GetCustomIgnoreRules() {
@@ -22,22 +30,70 @@ export function GetGeminiAPIKey() {
   return window['go']['main']['App']['GetGeminiAPIKey']();
 }
 
+export function GetHardExcludedDirs(arg1) {
+  return window['go']['main']['App']['GetHardExcludedDirs'](arg1);
+}
+
+export function GetSymlinkPolicy() {
+  return window['go']['main']['App']['GetSymlinkPolicy']();
+}
+
+export function ListContextSnapshots() {
+  return window['go']['main']['App']['ListContextSnapshots']();
+}
+
 export function ListFiles(arg1) {
   return window['go']['main']['App']['ListFiles'](arg1);
 }
 
+export function ListWorkspaceFiles(arg1) {
+  return window['go']['main']['App']['ListWorkspaceFiles'](arg1);
+}
+
+export function ReadContextRange(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ReadContextRange'](arg1, arg2, arg3);
+}
+
+export function ReleaseContext(arg1) {
+  return window['go']['main']['App']['ReleaseContext'](arg1);
+}
+
 export function RequestShotgunContextGeneration(arg1, arg2) {
   return window['go']['main']['App']['RequestShotgunContextGeneration'](arg1, arg2);
 }
 
+export function RequestShotgunContextGenerationWithOptions(arg1, arg2, arg3) {
+  return window['go']['main']['App']['RequestShotgunContextGenerationWithOptions'](arg1, arg2, arg3);
+}
+
+export function RequestWorkspaceContextGeneration(arg1, arg2, arg3) {
+  return window['go']['main']['App']['RequestWorkspaceContextGeneration'](arg1, arg2, arg3);
+}
+
 export function ResetApplication() {
   return window['go']['main']['App']['ResetApplication']();
 }
 
+export function ResetHardExcludedDirs(arg1) {
+  return window['go']['main']['App']['ResetHardExcludedDirs'](arg1);
+}
+
+export function SaveContext(arg1) {
+  return window['go']['main']['App']['SaveContext'](arg1);
+}
+
+export function SelectArchive() {
+  return window['go']['main']['App']['SelectArchive']();
+}
+
 export function SelectDirectory() {
   return window['go']['main']['App']['SelectDirectory']();
 }
 
+export function SelectGoPackageDependencies(arg1, arg2, arg3) {
+  return window['go']['main']['App']['SelectGoPackageDependencies'](arg1, arg2, arg3);
+}
+
 export function SetCustomIgnoreRules(arg1) {
   return window['go']['main']['App']['SetCustomIgnoreRules'](arg1);
 }
@@ -50,6 +106,14 @@ export function SetGeminiAPIKey(arg1) {
   return window['go']['main']['App']['SetGeminiAPIKey'](arg1);
 }
 
+export function SetHardExcludedDirs(arg1, arg2) {
+  return window['go']['main']['App']['SetHardExcludedDirs'](arg1, arg2);
+}
+
+export function SetSymlinkPolicy(arg1) {
+  return window['go']['main']['App']['SetSymlinkPolicy'](arg1);
+}
+
 export function SetUseCustomIgnore(arg1) {
   return window['go']['main']['App']['SetUseCustomIgnore'](arg1);
 }
@@ -66,6 +130,10 @@ export function StartFileWatcher(arg1) {
   return window['go']['main']['App']['StartFileWatcher'](arg1);
 }
 
+export function StartWorkspaceWatcher(arg1) {
+  return window['go']['main']['App']['StartWorkspaceWatcher'](arg1);
+}
+
 export function StartupTest(arg1) {
   return window['go']['main']['App']['StartupTest'](arg1);
 }
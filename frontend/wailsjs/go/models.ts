@@ -1,5 +1,65 @@
 export namespace main {
 	
+	export class ContextOptions {
+	    format: string;
+	    tokenBudget: number;
+	    pinnedPaths: string[];
+	    includePatterns: string[];
+	    changedOnly: boolean;
+	    baseRef: string;
+	    includeNeighbors: boolean;
+	    includeDiffs: boolean;
+	    disableRedaction: boolean;
+	    disableDedup: boolean;
+	    transforms: Record<string, string[]>;
+	    excerptLines: number;
+	    lineNumbers: boolean;
+	    outlinePaths: string[];
+	    notebookOutputs: string;
+	    sinceSnapshot: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ContextOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.format = source["format"];
+	        this.tokenBudget = source["tokenBudget"];
+	        this.pinnedPaths = source["pinnedPaths"];
+	        this.includePatterns = source["includePatterns"];
+	        this.changedOnly = source["changedOnly"];
+	        this.baseRef = source["baseRef"];
+	        this.includeNeighbors = source["includeNeighbors"];
+	        this.includeDiffs = source["includeDiffs"];
+	        this.disableRedaction = source["disableRedaction"];
+	        this.disableDedup = source["disableDedup"];
+	        this.transforms = source["transforms"];
+	        this.excerptLines = source["excerptLines"];
+	        this.lineNumbers = source["lineNumbers"];
+	        this.outlinePaths = source["outlinePaths"];
+	        this.notebookOutputs = source["notebookOutputs"];
+	        this.sinceSnapshot = source["sinceSnapshot"];
+	    }
+	}
+	export class ContextRange {
+	    data: string;
+	    offset: number;
+	    next: number;
+	    eof: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ContextRange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.data = source["data"];
+	        this.offset = source["offset"];
+	        this.next = source["next"];
+	        this.eof = source["eof"];
+	    }
+	}
 	export class FileNode {
 	    name: string;
 	    path: string;
@@ -8,6 +68,9 @@ export namespace main {
 	    children?: FileNode[];
 	    isGitignored: boolean;
 	    isCustomIgnored: boolean;
+	    gitStatus?: string;
+	    symlinkTarget?: string;
+	    symlinkNote?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new FileNode(source);
@@ -22,6 +85,92 @@ export namespace main {
 	        this.children = this.convertValues(source["children"], FileNode);
 	        this.isGitignored = source["isGitignored"];
 	        this.isCustomIgnored = source["isCustomIgnored"];
+	        this.gitStatus = source["gitStatus"];
+	        this.symlinkTarget = source["symlinkTarget"];
+	        this.symlinkNote = source["symlinkNote"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class GoDependencyOptions {
+	    includeTests: boolean;
+	    maxDepth: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new GoDependencyOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.includeTests = source["includeTests"];
+	        this.maxDepth = source["maxDepth"];
+	    }
+	}
+	export class GoDependencySelection {
+	    packages: string[];
+	    files: string[];
+	    includePatterns: string[];
+	    missing?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new GoDependencySelection(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.packages = source["packages"];
+	        this.files = source["files"];
+	        this.includePatterns = source["includePatterns"];
+	        this.missing = source["missing"];
+	    }
+	}
+	export class HardExclusions {
+	    dirs: string[];
+	    project: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new HardExclusions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.dirs = source["dirs"];
+	        this.project = source["project"];
+	    }
+	}
+	export class SnapshotInfo {
+	    id: string;
+	    rootDir: string;
+	    // Go type: time
+	    created: any;
+	    files: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SnapshotInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.rootDir = source["rootDir"];
+	        this.created = this.convertValues(source["created"], null);
+	        this.files = source["files"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -42,6 +191,22 @@ export namespace main {
 		    return a;
 		}
 	}
+	export class WorkspaceRoot {
+	    alias: string;
+	    path: string;
+	    ref?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceRoot(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.alias = source["alias"];
+	        this.path = source["path"];
+	        this.ref = source["ref"];
+	    }
+	}
 
 }
 
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+)
+
+// --- git-accurate ignore evaluation ---
+
+// gitignorematcher answers "would git ignore this path?" for a project root. it follows
+// the rules of gitignore(5):
+//
+//   - sources, from lowest to highest precedence: core.excludesfile, .git/info/exclude,
+//     then every .gitignore from the repository top-level down to the path's directory
+//   - a pattern in a/.gitignore only applies to paths below a/
+//   - within all sources the last matching pattern wins, "!" re-includes
+//   - nothing below an ignored directory can be re-included
+//
+// the root does not have to be the repository top-level; .gitignore files between the
+// top-level and the root apply as well. per-directory files are loaded lazily and cached
+// until invalidate is called (e.g. when the watcher sees a .gitignore change).
+type gitIgnoreMatcher struct {
+	root       string // absolute root the relative paths passed in are based on
+	repoTop    string // repository work tree top-level (root itself when not inside a repo)
+	rootPrefix string // root relative to repotop, slash separated, "" when equal
+	global     []ignoreRule
+
+	mu       sync.Mutex
+	dirRules map[string][]ignoreRule // .gitignore rules keyed by directory relative to repotop
+	tree     *virtualTree            // the .gitignore files are read from it for an archive
+}
+
+// ignorerule is one compiled pattern line.
+type ignoreRule struct {
+	base     string // directory (relative to repotop) the pattern is scoped to, "" for top-level
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contains a slash: match the whole path below base, not only the name
+}
+
+// newgitignorematcher discovers the enclosing git repository of root (if any) and loads
+// the global sources. .gitignore files are always honoured, even outside a repository,
+// which keeps the behaviour of the earlier root-only implementation for plain folders.
+func newGitIgnoreMatcher(root string) *gitIgnoreMatcher {
+	m := &gitIgnoreMatcher{root: root, repoTop: root, dirRules: make(map[string][]ignoreRule)}
+
+	top, gitDir := findGitRepository(root)
+	if top == "" {
+		return m
+	}
+	m.repoTop = top
+	if rel, err := filepath.Rel(top, root); err == nil && rel != "." {
+		m.rootPrefix = filepath.ToSlash(rel)
+	}
+	commonDir := gitCommonDir(gitDir)
+
+	// lowest precedence first: core.excludesfile, then .git/info/exclude.
+	if excludesFile := gitExcludesFile(commonDir); excludesFile != "" {
+		m.global = append(m.global, loadIgnoreRules(excludesFile, "")...)
+	}
+	m.global = append(m.global, loadIgnoreRules(filepath.Join(commonDir, "info", "exclude"), "")...)
+	return m
+}
+
+// newVirtualGitIgnoreMatcher evaluates the .gitignore files inside a virtual tree. the
+// tree is not a repository, so there are no global sources.
+func newVirtualGitIgnoreMatcher(tree *virtualTree) *gitIgnoreMatcher {
+	return &gitIgnoreMatcher{root: tree.root, repoTop: tree.root, dirRules: make(map[string][]ignoreRule), tree: tree}
+}
+
+// match reports whether relpath (relative to the root, os-specific separators) is ignored
+// by its own rules. parent directories are not checked; callers that walk the tree top-down
+// already stop at ignored directories. use isignored for arbitrary paths.
+func (m *gitIgnoreMatcher) match(relPath string, isDir bool) bool {
+	if m == nil || relPath == "" || relPath == "." {
+		return false
+	}
+	topPath := filepath.ToSlash(relPath)
+	if m.rootPrefix != "" {
+		topPath = m.rootPrefix + "/" + topPath
+	}
+
+	ignored := false
+	apply := func(rules []ignoreRule) {
+		for _, r := range rules {
+			if r.matches(topPath, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	apply(m.global)
+	dir := path.Dir(topPath)
+	apply(m.rulesFor(""))
+	if dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			apply(m.rulesFor(strings.Join(parts[:i+1], "/")))
+		}
+	}
+	return ignored
+}
+
+// isignored is like match but also reports paths inside an ignored directory.
+func (m *gitIgnoreMatcher) isIgnored(relPath string, isDir bool) bool {
+	if m == nil || relPath == "" || relPath == "." {
+		return false
+	}
+	parent := filepath.Dir(relPath)
+	if parent != "." && m.isIgnored(parent, true) {
+		return true
+	}
+	return m.match(relPath, isDir)
+}
+
+// invalidate drops the cached .gitignore rules so they are re-read on the next match.
+func (m *gitIgnoreMatcher) invalidate() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.dirRules = make(map[string][]ignoreRule)
+	m.mu.Unlock()
+}
+
+func (m *gitIgnoreMatcher) rulesFor(dir string) []ignoreRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rules, ok := m.dirRules[dir]; ok {
+		return rules
+	}
+	file := filepath.Join(m.repoTop, filepath.FromSlash(dir), ".gitignore")
+	var rules []ignoreRule
+	if m.tree != nil {
+		if r, err := m.tree.open(file); err == nil {
+			rules = parseIgnoreRules(r, dir)
+			r.Close()
+		}
+	} else {
+		rules = loadIgnoreRules(file, dir)
+	}
+	m.dirRules[dir] = rules
+	return rules
+}
+
+func (r ignoreRule) matches(topPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := topPath
+	if r.base != "" {
+		if !strings.HasPrefix(topPath, r.base+"/") {
+			return false
+		}
+		rel = topPath[len(r.base)+1:]
+	}
+	if r.anchored {
+		return r.re.MatchString(rel)
+	}
+	return r.re.MatchString(path.Base(rel))
+}
+
+// loadignorerules reads an ignore file; a missing or unreadable file yields no rules.
+func loadIgnoreRules(file, base string) []ignoreRule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseIgnoreRules(f, base)
+}
+
+// parseIgnoreRules compiles the lines of an ignore file.
+func parseIgnoreRules(r io.Reader, base string) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if r, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parseignoreline compiles one gitignore line. ok is false for blank lines, comments
+// and patterns that cannot be compiled.
+func parseIgnoreLine(line, base string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+	// trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	r := ignoreRule{base: base}
+	switch {
+	case line[0] == '!':
+		r.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile(ignoreGlobToRegexp(line))
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// ignoreglobtoregexp translates a gitignore glob into an anchored regular expression.
+// "*" and "?" never match "/", "**" matches across directories when it forms a whole
+// path segment ("**/x", "x/**", "a/**/b"), and "[...]" classes and "\" escapes work as
+// in fnmatch.
+func ignoreGlobToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			j := i
+			for j < len(glob) && glob[j] == '*' {
+				j++
+			}
+			segmentStart := i == 0 || glob[i-1] == '/'
+			segmentEnd := j == len(glob) || glob[j] == '/'
+			switch {
+			case j-i >= 2 && segmentStart && j == len(glob):
+				sb.WriteString(".*") // trailing "/**" (or a lone "**"): everything inside
+			case j-i >= 2 && segmentStart && segmentEnd:
+				sb.WriteString("(?:.*/)?") // "**/": zero or more directories
+				j++                        // the slash is part of the group
+			default:
+				sb.WriteString("[^/]*")
+			}
+			i = j - 1
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == 0 && i+2 < len(glob) { // "[]...]": the first ] is a literal
+				if next := strings.IndexByte(glob[i+2:], ']'); next >= 0 {
+					end = next + 1
+				} else {
+					end = -1
+				}
+			}
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `[`, `\[`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// findgitrepository walks up from dir to the first directory containing .git and returns
+// that work tree top-level and its git directory, or empty strings outside a repository.
+func findGitRepository(dir string) (top, gitDir string) {
+	for current := dir; ; {
+		candidate := filepath.Join(current, ".git")
+		if info, err := os.Stat(candidate); err == nil {
+			if info.IsDir() {
+				return current, candidate
+			}
+			// worktrees and submodules use a "gitdir: <path>" file
+			if data, err := os.ReadFile(candidate); err == nil {
+				line := strings.TrimSpace(string(data))
+				if strings.HasPrefix(line, "gitdir:") {
+					target := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(current, target)
+					}
+					return current, target
+				}
+			}
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", ""
+		}
+		current = parent
+	}
+}
+
+// gitcommondir resolves the shared git directory of a linked worktree; for a normal
+// repository it is the git directory itself.
+func gitCommonDir(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	common := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDir, common)
+	}
+	return common
+}
+
+// gitexcludesfile returns the path of core.excludesfile. the repository config wins over
+// ~/.gitconfig, which wins over $xdg_config_home/git/config; when the option is not set
+// git falls back to $xdg_config_home/git/ignore.
+func gitExcludesFile(commonDir string) string {
+	home, _ := os.UserHomeDir()
+	configs := []string{filepath.Join(commonDir, "config")}
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+	configs = append(configs, filepath.Join(xdg.ConfigHome, "git", "config"))
+
+	for _, cfg := range configs {
+		if value, ok := readGitConfigValue(cfg, "core", "excludesfile"); ok {
+			if value == "" {
+				return ""
+			}
+			if strings.HasPrefix(value, "~/") && home != "" {
+				value = filepath.Join(home, value[2:])
+			}
+			return value
+		}
+	}
+	return filepath.Join(xdg.ConfigHome, "git", "ignore")
+}
+
+// readgitconfigvalue is a minimal git config reader: it understands [section] headers and
+// "key = value" lines, which is all that is needed for core.excludesfile. section and key
+// are matched case-insensitively; the last occurrence wins like in git.
+func readGitConfigValue(configPath, section, key string) (string, bool) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	var value string
+	found := false
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			name := strings.TrimSpace(strings.Trim(line, "[]"))
+			inSection = strings.EqualFold(name, section)
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		k, v, hasValue := strings.Cut(line, "=")
+		if !strings.EqualFold(strings.TrimSpace(k), key) {
+			continue
+		}
+		found = true
+		value = ""
+		if hasValue {
+			v = strings.TrimSpace(v)
+			if i := strings.IndexAny(v, "#;"); i >= 0 && !strings.HasPrefix(v, `"`) {
+				v = strings.TrimSpace(v[:i])
+			}
+			value = strings.Trim(v, `"`)
+		}
+	}
+	return value, found
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestIgnoreGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.log", "debug.log", true},
+		{"*.log", "dir/debug.log", false}, // "*" never crosses a slash
+		{"?.go", "a.go", true},
+		{"?.go", "ab.go", false},
+		{"**/build", "build", true},
+		{"**/build", "a/b/build", true},
+		{"docs/**", "docs/a/b.md", true},
+		{"docs/**", "docs", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a**b", "a/b", false}, // not a whole segment: a plain star
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "b.txt", false},
+		{"[!abc].txt", "d.txt", true},
+		{"[].txt", "[].txt", true}, // an unterminated class is literal
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+		{"a+b(c).txt", "a+b(c).txt", true},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(ignoreGlobToRegexp(tt.glob))
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v (regexp %s)", tt.glob, tt.path, got, tt.want, re)
+		}
+	}
+}
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		line                      string
+		ok, negate, dir, anchored bool
+	}{
+		{"", false, false, false, false},
+		{"# comment", false, false, false, false},
+		{`\#file`, true, false, false, false},
+		{"!keep.log", true, true, false, false},
+		{`\!bang`, true, false, false, false},
+		{"build/", true, false, true, false},
+		{"/root.txt", true, false, false, true},
+		{"a/b", true, false, false, true},
+		{"trailing   ", true, false, false, false},
+		{"/", false, false, false, false},
+	}
+	for _, tt := range tests {
+		r, ok := parseIgnoreLine(tt.line, "")
+		if ok != tt.ok {
+			t.Errorf("parseIgnoreLine(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if ok && (r.negate != tt.negate || r.dirOnly != tt.dir || r.anchored != tt.anchored) {
+			t.Errorf("parseIgnoreLine(%q) = negate %v, dirOnly %v, anchored %v; want %v, %v, %v",
+				tt.line, r.negate, r.dirOnly, r.anchored, tt.negate, tt.dir, tt.anchored)
+		}
+	}
+	if r, _ := parseIgnoreLine("trailing   ", ""); !r.re.MatchString("trailing") {
+		t.Errorf("unescaped trailing spaces are not trimmed")
+	}
+}
+
+// writeFiles creates files below root from slash paths.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGitIgnoreMatcher(t *testing.T) {
+	repo := t.TempDir()
+	excludes := filepath.Join(t.TempDir(), "global-ignore")
+	writeFiles(t, repo, map[string]string{
+		".git/config":       "[core]\n\texcludesFile = " + excludes + "\n",
+		".git/info/exclude": "*.tmp\n",
+		".gitignore":        "*.log\n!keep.log\nbuild/\n/root-only.txt\ndocs/**/*.pdf\n*.bak\n",
+		"sub/.gitignore":    "*.txt\n!important.txt\n!*.bak\n",
+		"sub/deep/x.txt":    "",
+	})
+	writeFiles(t, filepath.Dir(excludes), map[string]string{"global-ignore": "*.swp\n*.tmp\n!local.tmp\n"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"sub/debug.log", false, true}, // unanchored patterns apply at any depth
+		{"keep.log", false, false},     // the later negation wins
+		{"build", true, true},
+		{"build", false, false}, // build/ only matches directories
+		{"build/keep.txt", false, true},
+		{"root-only.txt", false, true},
+		{"sub/root-only.txt", false, true}, // ignored by sub/.gitignore's *.txt instead
+		{"other/root-only.txt", false, false},
+		{"docs/a/b/manual.pdf", false, true},
+		{"docs/manual.pdf", false, true},
+		{"sub/notes.txt", false, true},
+		{"sub/deep/x.txt", false, true},
+		{"sub/important.txt", false, false},
+		{"important.txt", false, false},
+		{"a.bak", false, true},
+		{"sub/a.bak", false, false}, // sub/.gitignore re-includes below sub only
+		{"x.swp", false, true},      // core.excludesFile
+		{"x.tmp", false, true},
+		{"local.tmp", false, true}, // .git/info/exclude beats the negation in core.excludesFile
+		{"main.go", false, false},
+	}
+	m := newGitIgnoreMatcher(repo)
+	for _, tt := range tests {
+		if got := m.isIgnored(filepath.FromSlash(tt.path), tt.isDir); got != tt.want {
+			t.Errorf("isIgnored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestGitIgnoreMatcherBelowRepositoryTop(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		".git/HEAD":             "ref: refs/heads/main\n",
+		".gitignore":            "/project/generated/\n*.out\n",
+		"project/.gitignore":    "local/\n",
+		"project/generated/a.c": "",
+	})
+	m := newGitIgnoreMatcher(filepath.Join(repo, "project"))
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"generated", true, true}, // anchored to the repository top, not the root
+		{"a.out", false, true},
+		{"local", true, true},
+		{"src/local", true, true},
+		{"src/main.c", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.isIgnored(filepath.FromSlash(tt.path), tt.isDir); got != tt.want {
+			t.Errorf("isIgnored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestVirtualGitIgnoreMatcher(t *testing.T) {
+	tree := newVirtualTree(filepath.Join(t.TempDir(), "repro.zip"))
+	for name, content := range map[string]string{
+		".gitignore":     "*.log\n",
+		"pkg/.gitignore": "gen/\n",
+	} {
+		data := []byte(content)
+		tree.addFile(name, &virtualFile{size: int64(len(data)), open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}})
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},
+		{"pkg/gen", true, true},
+		{"gen", true, false}, // pkg/.gitignore only applies below pkg
+		{"pkg/a.go", false, false},
+	}
+	m := newVirtualGitIgnoreMatcher(tree)
+	for _, tt := range tests {
+		if got := m.isIgnored(filepath.FromSlash(tt.path), tt.isDir); got != tt.want {
+			t.Errorf("isIgnored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
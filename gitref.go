@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- roots at a git ref ---
+
+// a root with WorkspaceRoot.Ref set is read as the repository was at that ref (a
+// branch, a tag such as v1.4.2, or a commit), straight from the local object store: the
+// files are listed with git ls-tree and every blob is read with git cat-file when its
+// content is needed. a context generation reads all of its blobs through one
+// git cat-file --batch process that ends with the generation; outside of one (e.g. the
+// .gitignore files read while listing) every blob is read by its own git cat-file. the
+// working tree is never touched, so nothing has to be checked out. the tree is a
+// virtual tree (see virtualtree.go) and gets the ignore rules, exclusions and limits of
+// a folder; it is not watched and has no git status. the tree and the context name the
+// ref and the commit it resolved to.
+
+// gitRefModeSymlink is the ls-tree mode of a symbolic link; the blob holds the target.
+const gitRefModeSymlink = "120000"
+
+// resolveGitCommit returns the full hash of the commit ref points to in the repository
+// of rootDir.
+func resolveGitCommit(ctx context.Context, rootDir, ref string) (string, error) {
+	out, err := runGit(ctx, rootDir, nil, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		if err == errNotAGitRepository {
+			return "", fmt.Errorf("%s is not inside a git repository, it has no ref %s", rootDir, ref)
+		}
+		return "", fmt.Errorf("unknown git ref %q in %s", ref, rootDir)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// openGitRefTree lists the files below rootDir in commit as a virtual tree.
+func openGitRefTree(ctx context.Context, rootDir, ref, commit string) (*virtualTree, error) {
+	prefixOut, err := runGit(ctx, rootDir, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSpace(string(prefixOut)) // root relative to the work tree top, with a trailing slash
+	timeOut, err := runGit(ctx, rootDir, nil, "show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return nil, err
+	}
+	var modTime time.Time
+	if secs, err := strconv.ParseInt(strings.TrimSpace(string(timeOut)), 10, 64); err == nil {
+		modTime = time.Unix(secs, 0)
+	}
+	args := []string{"ls-tree", "-r", "-l", "-z", "--full-tree", commit}
+	if prefix != "" {
+		args = append(args, "--", ":(literal)"+prefix)
+	}
+	listing, err := runGit(ctx, rootDir, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	var links *gitBlobReader // started for the first symbolic link
+	defer func() {
+		if links != nil {
+			links.close()
+		}
+	}()
+
+	tree := newVirtualTree(rootDir)
+	tree.ref, tree.commit = ref, commit
+	// "<mode> SP <type> SP <object> SP+ <size> TAB <path>" per entry, nul terminated
+	for _, entry := range strings.Split(string(listing), "\x00") {
+		meta, topPath, ok := strings.Cut(entry, "\t")
+		if !ok || !strings.HasPrefix(topPath, prefix) {
+			continue
+		}
+		p, ok := cleanVirtualPath(strings.TrimPrefix(topPath, prefix))
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 {
+			continue
+		}
+		mode, kind, object := fields[0], fields[1], fields[2]
+		switch {
+		case kind == "commit": // a submodule, listed as an empty directory
+			tree.addDir(p)
+		case kind != "blob":
+		case mode == gitRefModeSymlink:
+			if links == nil {
+				if links, err = startGitBlobReader(ctx, rootDir); err != nil {
+					return nil, err
+				}
+			}
+			target, err := links.read(object)
+			if err != nil {
+				return nil, err
+			}
+			tree.addFile(p, &virtualFile{modTime: modTime, link: string(target)})
+		default:
+			size, _ := strconv.ParseInt(fields[3], 10, 64)
+			tree.addFile(p, &virtualFile{size: size, modTime: modTime, object: object, open: gitBlobOpener(rootDir, object)})
+		}
+	}
+	return tree, nil
+}
+
+// gitBlobOpener reads a blob on demand when no generation is running, e.g. a .gitignore
+// file while the tree is listed; a single blob is quick to read.
+func gitBlobOpener(rootDir, object string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		data, err := runGit(context.Background(), rootDir, nil, "cat-file", "blob", object)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// gitBlobReader reads blobs through one git cat-file --batch process. the process ends
+// with the context it was started with or with close; requests are served one at a
+// time.
+type gitBlobReader struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	err    error // set once the process cannot be used any more
+}
+
+func startGitBlobReader(ctx context.Context, rootDir string) (*gitBlobReader, error) {
+	cmd := exec.CommandContext(ctx, "git", "-C", rootDir, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	return &gitBlobReader{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 64*1024)}, nil
+}
+
+// read returns the content of the blob object.
+func (r *gitBlobReader) read(object string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	// the reply is "<object> <type> <size>\n<content>\n", or "<object> missing\n"
+	if _, err := io.WriteString(r.stdin, object+"\n"); err != nil {
+		r.err = fmt.Errorf("git cat-file: %w", err)
+		return nil, r.err
+	}
+	header, err := r.stdout.ReadString('\n')
+	if err != nil {
+		r.err = fmt.Errorf("git cat-file: %w", err)
+		return nil, r.err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: object %s: %s", object, strings.TrimSpace(header))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		r.err = fmt.Errorf("git cat-file: unexpected reply %q", strings.TrimSpace(header))
+		return nil, r.err
+	}
+	data := make([]byte, size+1) // with the trailing newline
+	if _, err := io.ReadFull(r.stdout, data); err != nil {
+		r.err = fmt.Errorf("git cat-file: %w", err)
+		return nil, r.err
+	}
+	return data[:size], nil
+}
+
+// close ends the process.
+func (r *gitBlobReader) close() {
+	r.stdin.Close()
+	r.cmd.Wait()
+}
+
+// forRun returns the tree to read a context generation from: for a tree at a git ref, a
+// view that reads every blob through one git process bound to ctx, which done ends. it
+// is nil-safe, and other trees are returned as they are.
+func (t *virtualTree) forRun(ctx context.Context) (*virtualTree, func(), error) {
+	if t == nil || t.commit == "" {
+		return t, func() {}, nil
+	}
+	blobs, err := startGitBlobReader(ctx, t.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	view := *t
+	view.blobs = blobs
+	return &view, blobs.close, nil
+}
+
+// openGitRefRoot returns the tree of a root at a git ref. the ref is resolved on every
+// call, so a branch that moved is listed again; an unchanged commit comes from the
+// cache.
+func (a *App) openGitRefRoot(root WorkspaceRoot) (*virtualTree, error) {
+	if isArchiveRoot(root.Path) {
+		return nil, fmt.Errorf("%s is an archive, it has no git ref %s", root.Path, root.Ref)
+	}
+	ctx := context.TODO()
+	commit, err := resolveGitCommit(ctx, root.Path, root.Ref)
+	if err != nil {
+		return nil, err
+	}
+	return a.virtualTrees.get(root.Path+"@"+root.Ref, commit, func() (*virtualTree, error) {
+		return openGitRefTree(ctx, root.Path, root.Ref, commit)
+	})
+}
+
+// refLabel describes the ref of a virtual tree for the root line of trees, "" when the
+// tree is not at a git ref.
+func (t *virtualTree) refLabel() string {
+	if t == nil || t.commit == "" {
+		return ""
+	}
+	return " @ " + t.ref + " (commit " + t.commit + ")"
+}
+
+// shortRefLabel is refLabel with the short commit, for the root node of the file tree.
+func (t *virtualTree) shortRefLabel() string {
+	if t == nil || t.commit == "" {
+		return ""
+	}
+	return " @ " + t.ref + " (" + t.commit[:min(len(t.commit), 12)] + ")"
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- git working tree status ---
+
+// the changed files mode asks the local git binary which files differ from head (or a
+// chosen base ref). git is only needed for this mode and for the status shown in the
+// file tree; everything else works on plain folders.
+
+// git statuses as reported in filenode.gitstatus and in the context report.
+const (
+	gitStatusModified   = "modified"
+	gitStatusAdded      = "added"
+	gitStatusDeleted    = "deleted"
+	gitStatusRenamed    = "renamed"
+	gitStatusCopied     = "copied"
+	gitStatusUntracked  = "untracked"
+	gitStatusConflicted = "conflicted"
+)
+
+const defaultGitBaseRef = "HEAD"
+
+// errNotAGitRepository is returned when the root is not inside a git work tree.
+var errNotAGitRepository = errors.New("not a git repository")
+
+// ChangedFile is a file that differs from the base ref.
+type ChangedFile struct {
+	Path   string `json:"path"` // forward slashes, relative to the root
+	Status string `json:"status"`
+}
+
+// GitChangesReport records which files a changed files context was built from.
+type GitChangesReport struct {
+	BaseRef string        `json:"baseRef"`
+	Changed []ChangedFile `json:"changed"`
+	// deleted files cannot be inlined; they are listed so the model knows they are gone.
+	Deleted []string `json:"deleted"`
+}
+
+// runGit runs git in dir and returns its standard output. okExitCodes lists non-zero
+// exit codes that are not errors (git diff --no-index exits with 1 when files differ).
+func runGit(ctx context.Context, dir string, okExitCodes []int, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		for _, code := range okExitCodes {
+			if exitErr.ExitCode() == code {
+				return stdout.Bytes(), nil
+			}
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "not a git repository") {
+			return nil, errNotAGitRepository
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitStatusFromCode maps a porcelain status letter (or name-status letter) to a status.
+func gitStatusFromCode(code byte) string {
+	switch code {
+	case 'A':
+		return gitStatusAdded
+	case 'D':
+		return gitStatusDeleted
+	case 'R':
+		return gitStatusRenamed
+	case 'C':
+		return gitStatusCopied
+	case 'U':
+		return gitStatusConflicted
+	case '?':
+		return gitStatusUntracked
+	default:
+		return gitStatusModified
+	}
+}
+
+// gitChangedFiles returns the files below rootDir that differ from baseRef ("" means
+// head): staged, unstaged and untracked changes. keys are os-specific paths relative to
+// rootdir, as produced by the tree walks. renamedFrom maps a renamed file to its
+// original path; that path is reported as deleted unless a file took its place.
+func gitChangedFiles(ctx context.Context, rootDir, baseRef string) (changes, renamedFrom map[string]string, err error) {
+	if info, err := os.Stat(rootDir); err == nil && !info.IsDir() {
+		return nil, nil, errNotAGitRepository // an archive, see archive.go
+	}
+	prefixOut, err := runGit(ctx, rootDir, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := strings.TrimSpace(string(prefixOut)) // root relative to the work tree top, with a trailing slash
+
+	changes, renamedFrom = make(map[string]string), make(map[string]string)
+	add := func(topPath, status string) {
+		if !strings.HasPrefix(topPath, prefix) {
+			return // outside the selected root
+		}
+		changes[filepath.FromSlash(strings.TrimPrefix(topPath, prefix))] = status
+	}
+	rename := func(topPath, origPath string) {
+		if strings.HasPrefix(topPath, prefix) && strings.HasPrefix(origPath, prefix) {
+			renamedFrom[filepath.FromSlash(strings.TrimPrefix(topPath, prefix))] = filepath.FromSlash(strings.TrimPrefix(origPath, prefix))
+		}
+	}
+
+	// porcelain v1 with -z: "XY path\0", followed by "orig\0" for renames and copies
+	statusOut, err := runGit(ctx, rootDir, nil, "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := strings.Split(string(statusOut), "\x00")
+	for i := 0; i < len(fields); i++ {
+		entry := fields[i]
+		if len(entry) < 4 {
+			continue
+		}
+		x, y, topPath := entry[0], entry[1], entry[3:]
+		if x == 'R' || x == 'C' {
+			i++ // the original path
+			if x == 'R' && i < len(fields) && (baseRef == "" || baseRef == defaultGitBaseRef) {
+				rename(topPath, fields[i])
+			}
+		}
+		if baseRef != "" && baseRef != defaultGitBaseRef && !(x == '?' && y == '?') {
+			continue // tracked changes come from git diff against the base ref below
+		}
+		switch {
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			add(topPath, gitStatusConflicted)
+		case y == 'D' || (x == 'D' && y == ' '):
+			add(topPath, gitStatusDeleted)
+		case x != ' ':
+			add(topPath, gitStatusFromCode(x))
+		default:
+			add(topPath, gitStatusFromCode(y))
+		}
+	}
+
+	if baseRef != "" && baseRef != defaultGitBaseRef {
+		// name-status with -z: "S\0path\0", "R100\0old\0new\0" for renames and copies
+		diffOut, err := runGit(ctx, rootDir, nil, "diff", "--no-color", "--no-ext-diff", "-M", "--name-status", "-z", baseRef, "--")
+		if err != nil {
+			return nil, nil, err
+		}
+		fields := strings.Split(string(diffOut), "\x00")
+		for i := 0; i < len(fields); i++ {
+			code := fields[i]
+			if code == "" {
+				continue
+			}
+			origPath := ""
+			if code[0] == 'R' || code[0] == 'C' {
+				i++ // the original path
+				if code[0] == 'R' && i < len(fields) {
+					origPath = fields[i]
+				}
+			}
+			if i+1 >= len(fields) {
+				break
+			}
+			i++
+			add(fields[i], gitStatusFromCode(code[0]))
+			if origPath != "" {
+				rename(fields[i], origPath)
+			}
+		}
+	}
+	for _, origPath := range renamedFrom {
+		if changes[origPath] == "" {
+			changes[origPath] = gitStatusDeleted
+		}
+	}
+	return changes, renamedFrom, nil
+}
+
+// gitFileDiff returns the diff of one file (relative to rootdir) against baseRef.
+// untracked files, and added files in a repository without commits, are diffed
+// against an empty file. a renamed file is diffed against its original path, given as
+// origPath.
+func gitFileDiff(ctx context.Context, rootDir, baseRef, relPath, status, origPath string) (string, error) {
+	if baseRef == "" {
+		baseRef = defaultGitBaseRef
+	}
+	pathspec := filepath.ToSlash(relPath)
+	diffAgainstEmpty := func() (string, error) {
+		out, err := runGit(ctx, rootDir, []int{1}, "diff", "--no-color", "--no-ext-diff", "--no-index", "--", "/dev/null", pathspec)
+		return string(out), err
+	}
+	if status == gitStatusUntracked {
+		return diffAgainstEmpty()
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff", "-M", baseRef, "--", pathspec}
+	if origPath != "" {
+		args = append(args, filepath.ToSlash(origPath))
+	}
+	out, err := runGit(ctx, rootDir, nil, args...)
+	if err != nil && status == gitStatusAdded {
+		return diffAgainstEmpty()
+	}
+	return string(out), err
+}
+
+// loadgitdiff returns the diff of a changed context file for its <file> block. it runs
+// on the read pool; failures are reported inside the block rather than failing the job.
+func (a *App) loadGitDiff(ctx context.Context, baseRef string, f contextFileEntry) string {
+	relPath, _ := filepath.Rel(f.rootDir, f.absPath) // without the alias of a workspace root
+	diff, err := gitFileDiff(ctx, f.rootDir, baseRef, relPath, f.gitStatus, f.renamedFrom)
+	if err != nil {
+		a.logWarningf("git diff failed for %s: %v", f.relPath, err)
+		return fmt.Sprintf("error reading git diff: %v", err)
+	}
+	return diff
+}
+
+// newGitChangesReport lists changes sorted by path, deleted files separately.
+func newGitChangesReport(baseRef string, changes map[string]string) *GitChangesReport {
+	if baseRef == "" {
+		baseRef = defaultGitBaseRef
+	}
+	report := &GitChangesReport{BaseRef: baseRef, Changed: []ChangedFile{}, Deleted: []string{}}
+	for relPath, status := range changes {
+		if status == gitStatusDeleted {
+			report.Deleted = append(report.Deleted, filepath.ToSlash(relPath))
+			continue
+		}
+		report.Changed = append(report.Changed, ChangedFile{Path: filepath.ToSlash(relPath), Status: status})
+	}
+	sort.Strings(report.Deleted)
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].Path < report.Changed[j].Path })
+	return report
+}
+
+// annotateGitStatus sets filenode.gitstatus for changed files; nodes of unchanged files
+// keep an empty status.
+func annotateGitStatus(nodes []*FileNode, changes map[string]string) {
+	for _, node := range nodes {
+		if node.IsDir {
+			annotateGitStatus(node.Children, changes)
+			continue
+		}
+		node.GitStatus = changes[node.RelPath]
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- go package dependencies ---
+
+// a task that touches one go package usually needs the packages it imports from the
+// same module as well. SelectGoPackageDependencies reads the module path from go.mod,
+// parses the imports of every .go file of the requested packages and follows the ones
+// inside the module, breadth first. the result lists the files and, ready for
+// ContextOptions.IncludePatterns, one anchored pattern per file.
+//
+// imports are read with go/parser from every file of a package directory, whatever its
+// build constraints, so platform-specific files are part of the selection. directories
+// with their own go.mod belong to another module and are not followed.
+
+// GoDependencyOptions tune SelectGoPackageDependencies.
+type GoDependencyOptions struct {
+	IncludeTests bool `json:"includeTests"` // also select _test.go files and follow their imports
+	MaxDepth     int  `json:"maxDepth"`     // import levels to follow, 0 for all; 1 adds the direct imports
+}
+
+// GoDependencySelection is the transitive in-module closure of some go packages.
+type GoDependencySelection struct {
+	Packages        []string `json:"packages"`          // import paths, sorted
+	Files           []string `json:"files"`             // context paths with forward slashes, sorted
+	IncludePatterns []string `json:"includePatterns"`   // one anchored include pattern per file
+	Missing         []string `json:"missing,omitempty"` // requested packages that were not found
+}
+
+// goModule is the module at the root of a workspace root.
+type goModule struct {
+	root WorkspaceRoot
+	path string // module path from go.mod
+}
+
+// readGoModulePath returns the module path declared in the go.mod file at gomodPath.
+func readGoModulePath(gomodPath string) (string, error) {
+	f, err := os.Open(gomodPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		rest, ok := strings.CutPrefix(line, "module")
+		if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t' && rest[0] != '"') {
+			continue
+		}
+		rest = strings.TrimSpace(rest)
+		if unquoted, err := strconv.Unquote(rest); err == nil {
+			rest = unquoted
+		}
+		if rest != "" {
+			return rest, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no module directive", gomodPath)
+}
+
+// goPackageFiles lists the .go files of the package in dir and the imports they declare.
+func goPackageFiles(dir string, includeTests bool) (files, imports []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if !includeTests && strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		files = append(files, name)
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ImportsOnly)
+		if file == nil {
+			return nil, nil, err
+		}
+		for _, spec := range file.Imports {
+			if p, err := strconv.Unquote(spec.Path.Value); err == nil && !seen[p] {
+				seen[p] = true
+				imports = append(imports, p)
+			}
+		}
+	}
+	return files, imports, nil
+}
+
+// resolveGoPackage finds the module and the slash directory (relative to the module
+// root) of a package given as an import path or as a context path ("internal/billing",
+// alias first in a workspace). specs that lead outside the module root, such as
+// ../other, are not resolved.
+func resolveGoPackage(modules []goModule, spec string) (goModule, string, bool) {
+	spec = strings.TrimSuffix(filepath.ToSlash(strings.TrimSpace(spec)), "/")
+	for _, m := range modules {
+		if spec == m.path {
+			return m, ".", true
+		}
+		if rest, ok := strings.CutPrefix(spec, m.path+"/"); ok {
+			dir, ok := moduleSubdir(rest)
+			return m, dir, ok
+		}
+	}
+	rel := path.Clean(strings.TrimPrefix(spec, "./"))
+	for _, m := range modules {
+		if m.root.Alias == "" {
+			dir, ok := moduleSubdir(rel)
+			return m, dir, ok
+		}
+		if rel == m.root.Alias {
+			return m, ".", true
+		}
+		if rest, ok := strings.CutPrefix(rel, m.root.Alias+"/"); ok {
+			dir, ok := moduleSubdir(rest)
+			return m, dir, ok
+		}
+	}
+	return goModule{}, "", false
+}
+
+// moduleSubdir cleans a slash directory relative to a module root; ok is false when it
+// is absolute or leaves the root.
+func moduleSubdir(dir string) (string, bool) {
+	dir = path.Clean(dir)
+	if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", false
+	}
+	return dir, true
+}
+
+// SelectGoPackageDependencies returns the files of the given packages and of every
+// package they import from the same module. packages are import paths or directories
+// relative to the project (alias-prefixed in a workspace).
+func (a *App) SelectGoPackageDependencies(roots []WorkspaceRoot, packages []string, opts GoDependencyOptions) (*GoDependencySelection, error) {
+	roots, err := validateWorkspace(roots)
+	if err != nil {
+		return nil, err
+	}
+	var modules []goModule
+	for _, root := range roots {
+		if isArchiveRoot(root.Path) || root.Ref != "" {
+			continue // go packages are only resolved in the working tree of folders
+		}
+		modPath, err := readGoModulePath(filepath.Join(root.Path, "go.mod"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, goModule{root: root, path: modPath})
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("no go.mod found at the root of the project")
+	}
+
+	type pkgDir struct {
+		module goModule
+		dir    string // slash path relative to the module root
+		depth  int
+	}
+	selection := &GoDependencySelection{Packages: []string{}, Files: []string{}, IncludePatterns: []string{}}
+	visited := make(map[string]bool) // by import path
+	var queue []pkgDir
+	for _, spec := range packages {
+		m, dir, ok := resolveGoPackage(modules, spec)
+		if !ok {
+			selection.Missing = append(selection.Missing, spec)
+			continue
+		}
+		queue = append(queue, pkgDir{module: m, dir: dir})
+	}
+
+	requested := len(queue)
+	for i := 0; i < len(queue); i++ {
+		pkg := queue[i]
+		importPath := pkg.module.path
+		if pkg.dir != "." {
+			importPath += "/" + pkg.dir
+		}
+		if visited[importPath] {
+			continue
+		}
+		visited[importPath] = true
+
+		absDir := filepath.Join(pkg.module.root.Path, filepath.FromSlash(pkg.dir))
+		if pkg.dir != "." {
+			if _, err := os.Stat(filepath.Join(absDir, "go.mod")); err == nil {
+				continue // a nested module
+			}
+		}
+		files, imports, err := goPackageFiles(absDir, opts.IncludeTests)
+		if err != nil || len(files) == 0 {
+			if i < requested {
+				selection.Missing = append(selection.Missing, importPath)
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				a.logWarningf("selectgopackagedependencies: %s: %v", importPath, err)
+			}
+			continue
+		}
+		selection.Packages = append(selection.Packages, importPath)
+		for _, name := range files {
+			rel := pkg.module.root.relPath(filepath.Join(absDir, name))
+			selection.Files = append(selection.Files, filepath.ToSlash(rel))
+		}
+		if opts.MaxDepth > 0 && pkg.depth >= opts.MaxDepth {
+			continue
+		}
+		for _, imp := range imports {
+			if imp == pkg.module.path {
+				queue = append(queue, pkgDir{module: pkg.module, dir: ".", depth: pkg.depth + 1})
+			} else if rest, ok := strings.CutPrefix(imp, pkg.module.path+"/"); ok {
+				queue = append(queue, pkgDir{module: pkg.module, dir: rest, depth: pkg.depth + 1})
+			}
+		}
+	}
+
+	sort.Strings(selection.Packages)
+	sort.Strings(selection.Files)
+	for _, f := range selection.Files {
+		selection.IncludePatterns = append(selection.IncludePatterns, "/"+escapeIncludeGlob(f))
+	}
+	a.logInfof("selectgopackagedependencies: %d packages, %d files", len(selection.Packages), len(selection.Files))
+	return selection, nil
+}
+
+// escapeIncludeGlob quotes the glob characters of a literal path.
+func escapeIncludeGlob(p string) string {
+	var sb strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`*?[\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
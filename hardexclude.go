@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- hard exclusions ---
+
+// directories with one of these names are never listed, watched or read, in any root
+// and at any depth: version control data, dependency folders and build output that
+// would drown the context. unlike ignore rules they cannot be unchecked in the tree,
+// so the list is a setting (AppSettings.HardExcludedDirs) that a project can replace
+// with its own (ProjectHardExcludedDirs), e.g. to show a vendored fork or bazel rules
+// in build/. every walk gets the effective set through symlinkWalker.readDir and the
+// watcher, so listing, watching and generation agree.
+
+var defaultHardExcludedDirs = []string{
+	".bzr",
+	".cache",
+	".git",
+	".hg",
+	".idea",
+	".mypy_cache",
+	".next",
+	".nuxt",
+	".nyc_output",
+	".pytest_cache",
+	".svn",
+	".vscode",
+	"__pycache__",
+	"build",
+	"coverage",
+	"dist",
+	"node_modules",
+	"target", // rust/java
+	"vendor",
+}
+
+// HardExclusions is the effective hard exclusion list of a project.
+type HardExclusions struct {
+	Dirs    []string `json:"dirs"`    // directory names, sorted
+	Project bool     `json:"project"` // the project's own list rather than the global one
+}
+
+// normalizeHardExcludedDirs trims, dedupes and sorts directory names. a name is a single
+// path element; an empty list is kept, it shows every directory.
+func normalizeHardExcludedDirs(dirs []string) ([]string, error) {
+	seen := make(map[string]bool, len(dirs))
+	result := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		dir = strings.TrimSpace(dir)
+		switch {
+		case dir == "" || seen[dir]:
+			continue
+		case dir == "." || dir == ".." || strings.ContainsAny(dir, `/\`):
+			return nil, fmt.Errorf("invalid hard-excluded directory %q: it must be a plain directory name", dir)
+		}
+		seen[dir] = true
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// hardExclusionsFor returns the list that applies to the project (or workspace root) at
+// projectDir: its own list if it has one, the global list otherwise.
+func (a *App) hardExclusionsFor(projectDir string) HardExclusions {
+	if projectDir != "" {
+		if dirs, ok := a.settings.ProjectHardExcludedDirs[filepath.Clean(projectDir)]; ok {
+			return HardExclusions{Dirs: dirs, Project: true}
+		}
+	}
+	if a.settings.HardExcludedDirs != nil {
+		return HardExclusions{Dirs: a.settings.HardExcludedDirs}
+	}
+	return HardExclusions{Dirs: defaultHardExcludedDirs}
+}
+
+// hardExcludedDirSet is hardExclusionsFor as a set for the walkers.
+func (a *App) hardExcludedDirSet(projectDir string) map[string]bool {
+	dirs := a.hardExclusionsFor(projectDir).Dirs
+	set := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		set[dir] = true
+	}
+	return set
+}
+
+// GetHardExcludedDirs returns the directory names that are never shown for the project
+// at projectDir, or the global list when projectDir is empty.
+func (a *App) GetHardExcludedDirs(projectDir string) HardExclusions {
+	return a.hardExclusionsFor(projectDir)
+}
+
+// SetHardExcludedDirs saves the hard exclusion list of the project at projectDir, or the
+// global list when projectDir is empty, and reloads the watched tree.
+func (a *App) SetHardExcludedDirs(projectDir string, dirs []string) error {
+	dirs, err := normalizeHardExcludedDirs(dirs)
+	if err != nil {
+		return err
+	}
+	if projectDir == "" {
+		a.settings.HardExcludedDirs = dirs
+	} else {
+		if a.settings.ProjectHardExcludedDirs == nil {
+			a.settings.ProjectHardExcludedDirs = make(map[string][]string)
+		}
+		a.settings.ProjectHardExcludedDirs[filepath.Clean(projectDir)] = dirs
+	}
+	a.logInfof("hard-excluded directories of %q changed to: %s", projectDir, strings.Join(dirs, ", "))
+	return a.applyHardExclusions()
+}
+
+// ResetHardExcludedDirs drops the project's own list, so the global one applies again,
+// or restores the built-in global list when projectDir is empty.
+func (a *App) ResetHardExcludedDirs(projectDir string) error {
+	if projectDir == "" {
+		a.settings.HardExcludedDirs = nil
+	} else {
+		delete(a.settings.ProjectHardExcludedDirs, filepath.Clean(projectDir))
+	}
+	a.logInfof("hard-excluded directories of %q reset", projectDir)
+	return a.applyHardExclusions()
+}
+
+// applyHardExclusions saves the settings and restarts the watchers, which makes the
+// frontend reload the file tree.
+func (a *App) applyHardExclusions() error {
+	if err := a.saveSettings(); err != nil {
+		return fmt.Errorf("failed to save hard-excluded directories: %w", err)
+	}
+	if a.fileWatcher != nil && a.fileWatcher.isWatching() {
+		return a.fileWatcher.RefreshIgnoresAndRescan()
+	}
+	return nil
+}
+
+// isHardExcludedPath reports whether relPath lies in a hard-excluded directory, or is
+// one itself when isDir is set.
+func isHardExcludedPath(hidden map[string]bool, relPath string, isDir bool) bool {
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	if !isDir {
+		parts = parts[:len(parts)-1]
+	}
+	for _, part := range parts {
+		if hidden[part] {
+			return true
+		}
+	}
+	return false
+}
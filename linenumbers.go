@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// --- line-numbered file bodies ---
+
+// with contextoptions.linenumbers every content line of a block starts with its number
+// and lineNumberSeparator, right-aligned to the widest number of the file:
+//
+//	  9│ package main
+//	 10│
+//	 11│ import "fmt"
+//
+// the separator (U+2502, a box-drawing line) is rare in code, so the prompts can tell
+// the model to leave the prefix out of its answers, and SplitShotgunDiff can recognize
+// diffs where it did not: only hunks whose every line starts with a prefix of exactly
+// this shape are changed.
+
+const lineNumberSeparator = "│"
+
+// numberLines prefixes every line of content with its 1-based number.
+func numberLines(content string) string {
+	if content == "" {
+		return content
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1] // content ends with a newline
+	}
+	width := len(strconv.Itoa(len(lines)))
+	var sb strings.Builder
+	sb.Grow(len(content) + len(lines)*(width+len(lineNumberSeparator)+1))
+	for i, line := range lines {
+		num := strconv.Itoa(i + 1)
+		sb.WriteString(strings.Repeat(" ", width-len(num)))
+		sb.WriteString(num)
+		sb.WriteString(lineNumberSeparator)
+		if line != "\n" && line != "\r\n" {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// numberedSizeEstimate is the size of a file of size bytes once its lines are
+// numbered, assuming typical source lines of about 40 bytes.
+func numberedSizeEstimate(size int64) int64 {
+	return size + size*7/40
+}
+
+// diffLineNumberPrefix matches a hunk line ("+", "-" or " " followed by the content)
+// whose content starts with a copied line number prefix: the padding, the number, the
+// separator and the space before the content.
+var diffLineNumberPrefix = regexp.MustCompile(`^([ +-])( *)([1-9][0-9]*)` + lineNumberSeparator + `( ?)`)
+
+// stripDiffLineNumbers removes line number prefixes that a model copied from a
+// line-numbered context into the lines of a diff. a hunk is only changed when every one
+// of its lines carries a prefix in the shape numberLines writes, padded to the same
+// width, so ordinary diffs pass through untouched.
+func stripDiffLineNumbers(diff string) string {
+	if !strings.Contains(diff, lineNumberSeparator) {
+		return diff
+	}
+	lines := strings.Split(diff, "\n")
+	stripHunk := func(start, end int) {
+		body, width := 0, 0
+		for _, line := range lines[start:end] {
+			if line == "" || strings.HasPrefix(line, `\`) {
+				continue // blank trailing line or "\ no newline at end of file"
+			}
+			m := diffLineNumberPrefix.FindStringSubmatch(line)
+			if m == nil {
+				return
+			}
+			if body == 0 {
+				width = len(m[2]) + len(m[3])
+			}
+			rest := line[len(m[0]):]
+			if len(m[2])+len(m[3]) != width || m[4] == "" && rest != "" && rest != "\r" {
+				return // numberLines only leaves out the space on empty lines
+			}
+			body++
+		}
+		if body == 0 {
+			return
+		}
+		for i := start; i < end; i++ {
+			lines[i] = diffLineNumberPrefix.ReplaceAllString(lines[i], "$1")
+		}
+	}
+	hunkStart := -1
+	for i, line := range lines {
+		isBoundary := strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "diff --git ")
+		if isBoundary && hunkStart >= 0 {
+			stripHunk(hunkStart, i)
+			hunkStart = -1
+		}
+		if strings.HasPrefix(line, "@@") {
+			hunkStart = i + 1
+		}
+	}
+	if hunkStart >= 0 {
+		stripHunk(hunkStart, len(lines))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNumberLines(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"a", "1│ a"},
+		{"a\n\nb\n", "1│ a\n2│\n3│ b\n"},
+		{"x\r\n\r\n", "1│ x\r\n2│\r\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", " 1│ 1\n 2│ 2\n 3│ 3\n 4│ 4\n 5│ 5\n 6│ 6\n 7│ 7\n 8│ 8\n 9│ 9\n10│ 10\n"},
+	}
+	for _, tt := range tests {
+		if got := numberLines(tt.in); got != tt.want {
+			t.Errorf("numberLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripDiffLineNumbers(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"copied prefixes",
+			"--- a/x.go\n+++ b/x.go\n@@ -9,3 +9,3 @@\n  9│ package main\n-10│\n+10│ // new\n 11│ import \"fmt\"\n",
+			"--- a/x.go\n+++ b/x.go\n@@ -9,3 +9,3 @@\n package main\n-\n+// new\n import \"fmt\"\n"},
+		{"only the copied hunk",
+			"@@ -1 +1 @@\n-1│ a\n+1│ b\n@@ -5 +5 @@\n-box │ drawing\n+box │ art\n",
+			"@@ -1 +1 @@\n-a\n+b\n@@ -5 +5 @@\n-box │ drawing\n+box │ art\n"},
+		{"a line without prefix", "@@ -1,2 +1,2 @@\n-1│ a\n+b\n", "@@ -1,2 +1,2 @@\n-1│ a\n+b\n"},
+		{"mixed widths", "@@ -9,2 +9,2 @@\n-  9│ a\n+10│ b\n", "@@ -9,2 +9,2 @@\n-  9│ a\n+10│ b\n"},
+		{"no space before content", "@@ -1 +1 @@\n-1│a\n+1│b\n", "@@ -1 +1 @@\n-1│a\n+1│b\n"},
+		{"leading zero", "@@ -1 +1 @@\n-01│ a\n+01│ b\n", "@@ -1 +1 @@\n-01│ a\n+01│ b\n"},
+		{"table in code", "@@ -1 +1 @@\n-12│ │ cell\n+12│ │ cells\n", "@@ -1 +1 @@\n-│ cell\n+│ cells\n"},
+	}
+	for _, tt := range tests {
+		if got := stripDiffLineNumbers(tt.in); got != tt.want {
+			t.Errorf("%s: stripDiffLineNumbers(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
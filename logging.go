@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// --- logging and event routing ---
+
+// the wails runtime functions terminate the process when they are called with a context
+// that was not created by wails.Run, so everything that can run from the command line
+// logs and emits events through these helpers. in gui mode they forward to the wails
+// runtime unchanged; in headless mode log lines go to a.headless and events are handed
+// to a.onEvent (if set).
+
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarning
+	logLevelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case logLevelDebug:
+		return "debug"
+	case logLevelInfo:
+		return "info"
+	case logLevelWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// headlessLogger receives log lines when the app runs without a wails window.
+type headlessLogger struct {
+	out      io.Writer
+	minLevel logLevel
+}
+
+func (l *headlessLogger) log(level logLevel, msg string) {
+	if l == nil || l.out == nil || level < l.minLevel {
+		return
+	}
+	fmt.Fprintf(l.out, "shotgun: %s: %s\n", level, strings.TrimRight(msg, "\n"))
+}
+
+func (a *App) log(level logLevel, msg string) {
+	if a.headless != nil {
+		a.headless.log(level, msg)
+		return
+	}
+	if a.ctx == nil {
+		return // startup has not run yet; nothing to log to
+	}
+	switch level {
+	case logLevelDebug:
+		runtime.LogDebug(a.ctx, msg)
+	case logLevelInfo:
+		runtime.LogInfo(a.ctx, msg)
+	case logLevelWarning:
+		runtime.LogWarning(a.ctx, msg)
+	default:
+		runtime.LogError(a.ctx, msg)
+	}
+}
+
+func (a *App) logDebug(msg string)   { a.log(logLevelDebug, msg) }
+func (a *App) logInfo(msg string)    { a.log(logLevelInfo, msg) }
+func (a *App) logWarning(msg string) { a.log(logLevelWarning, msg) }
+func (a *App) logError(msg string)   { a.log(logLevelError, msg) }
+
+func (a *App) logDebugf(format string, args ...interface{}) {
+	a.log(logLevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (a *App) logInfof(format string, args ...interface{}) {
+	a.log(logLevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (a *App) logWarningf(format string, args ...interface{}) {
+	a.log(logLevelWarning, fmt.Sprintf(format, args...))
+}
+
+func (a *App) logErrorf(format string, args ...interface{}) {
+	a.log(logLevelError, fmt.Sprintf(format, args...))
+}
+
+// emitevent sends an event to the frontend, or to a.onEvent in headless mode.
+func (a *App) emitEvent(eventName string, optionalData ...interface{}) {
+	if a.headless != nil {
+		if a.onEvent != nil {
+			a.onEvent(eventName, optionalData...)
+		}
+		return
+	}
+	if a.ctx == nil {
+		return
+	}
+	runtime.EventsEmit(a.ctx, eventName, optionalData...)
+}
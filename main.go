@@ -37,6 +37,11 @@ func (a *app) selectdirectory() (string, error) {
 */
 
 func main() {
+	// subcommands such as "shotgun context <dir>" run headless and never open the window.
+	if isCLIInvocation(os.Args) {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	// parse command line arguments for an initial folder path. if the user drags
 	// a folder onto the executable, windows will pass the folder path as the
 	// first command line argument.
@@ -80,6 +85,7 @@ func main() {
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		OnStartup:        app.startup,
 		OnDomReady:       app.domReady,
+		OnShutdown:       app.shutdown,
 		Bind: []interface{}{
 			app, // this binds all public methods of app (including startup which may emit auto-open-folder)
 		},
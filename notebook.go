@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// --- jupyter notebooks ---
+
+// an .ipynb file is json: cell sources split into string arrays, execution metadata and
+// outputs with base64 images. inlined as is, most of its tokens are noise. notebooks
+// are flattened into the percent format instead: every cell in order under a
+// "# %% [code]", "# %% [markdown]" or "# %% [raw]" line, code cells followed by their
+// text outputs as "# " comments. images and other rich outputs become placeholders
+// and long outputs are cut after notebookOutputLines lines. the block of a flattened
+// notebook is marked notebook="flattened" and tagged with the kernel's language. a
+// notebook that does not parse is inlined as it is.
+
+// values of ContextOptions.NotebookOutputs.
+const (
+	notebookOutputsText = "text" // text outputs, truncated; the default
+	notebookOutputsNone = "none" // no outputs
+	notebookOutputsRaw  = "raw"  // no flattening, the notebook json is inlined
+)
+
+// notebookOutputLines is the number of lines kept of a single output.
+const notebookOutputLines = 20
+
+// NotebookReport is what flattening the notebooks saved over the whole context.
+type NotebookReport struct {
+	Files       int `json:"files"`
+	BytesSaved  int `json:"bytesSaved"`
+	TokensSaved int `json:"tokensSaved"`
+}
+
+// ansiEscape matches the color codes of tracebacks and progress bars in outputs.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+type notebookFile struct {
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+type notebookCell struct {
+	CellType string           `json:"cell_type"`
+	Source   json.RawMessage  `json:"source"`
+	Outputs  []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	OutputType string                     `json:"output_type"`
+	Text       json.RawMessage            `json:"text"`   // stream
+	Data       map[string]json.RawMessage `json:"data"`   // execute_result, display_data
+	EName      string                     `json:"ename"`  // error
+	EValue     string                     `json:"evalue"` // error
+}
+
+// validNotebookOutputs reports whether mode is a known ContextOptions.NotebookOutputs.
+func validNotebookOutputs(mode string) error {
+	switch mode {
+	case "", notebookOutputsText, notebookOutputsNone, notebookOutputsRaw:
+		return nil
+	}
+	return fmt.Errorf("unknown notebook outputs mode %q: use text, none or raw", mode)
+}
+
+// isNotebookPath reports whether relPath is a jupyter notebook.
+func isNotebookPath(relPath string) bool {
+	return strings.HasSuffix(strings.ToLower(relPath), ".ipynb")
+}
+
+// notebookText joins a multiline notebook string, which is either a string or an array
+// of lines.
+func notebookText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err == nil {
+		return strings.Join(lines, "")
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	return ""
+}
+
+// flattenNotebook renders the notebook src in the percent format and returns it with
+// the language of its kernel ("" when the notebook does not name one).
+func flattenNotebook(src, outputs string) (string, string, error) {
+	var nb notebookFile
+	if err := json.Unmarshal([]byte(src), &nb); err != nil {
+		return "", "", err
+	}
+	if nb.Cells == nil {
+		return "", "", fmt.Errorf("no cells")
+	}
+	language := strings.ToLower(nb.Metadata.KernelSpec.Language)
+	if language == "" {
+		language = strings.ToLower(nb.Metadata.LanguageInfo.Name)
+	}
+
+	var sb strings.Builder
+	for i, cell := range nb.Cells {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "# %%%% [%s]\n", cell.CellType)
+		if source := strings.TrimRight(notebookText(cell.Source), "\n"); source != "" {
+			sb.WriteString(source)
+			sb.WriteString("\n")
+		}
+		if cell.CellType != "code" || outputs == notebookOutputsNone {
+			continue
+		}
+		for _, output := range cell.Outputs {
+			text := renderNotebookOutput(output)
+			if text == "" {
+				continue
+			}
+			sb.WriteString("# [output]\n")
+			for _, line := range strings.Split(text, "\n") {
+				sb.WriteString(strings.TrimRight("# "+line, " "))
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return sb.String(), language, nil
+}
+
+// renderNotebookOutput returns the text of one output, truncated, or a placeholder for
+// rich data.
+func renderNotebookOutput(output notebookOutput) string {
+	var text string
+	switch output.OutputType {
+	case "stream":
+		text = notebookText(output.Text)
+	case "error":
+		text = output.EName + ": " + output.EValue
+	case "execute_result", "display_data":
+		var rich []string
+		for mime := range output.Data {
+			if mime != "text/plain" {
+				rich = append(rich, mime)
+			}
+		}
+		sort.Strings(rich)
+		for _, mime := range rich {
+			if strings.HasPrefix(mime, "image/") {
+				return "[" + mime + " output omitted]"
+			}
+		}
+		text = notebookText(output.Data["text/plain"])
+		if text == "" && len(rich) > 0 {
+			return "[" + rich[0] + " output omitted]"
+		}
+	}
+	text = strings.TrimRight(ansiEscape.ReplaceAllString(text, ""), "\n")
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if j := strings.LastIndex(strings.TrimSuffix(line, "\r"), "\r"); j >= 0 {
+			lines[i] = line[j+1:] // a progress bar redrawn in place
+		}
+	}
+	if len(lines) > notebookOutputLines {
+		more := len(lines) - notebookOutputLines
+		lines = append(lines[:notebookOutputLines], fmt.Sprintf("[... %d more lines]", more))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- go outlines ---
+
+// for large go services the api surface matters more than the function bodies. files
+// below ContextOptions.OutlinePaths are inlined as an outline: the header up to the
+// package clause, the imports, every type, const and var declaration, and the doc
+// comment and signature of every function and method with its body replaced by
+// "{ ... }". outlines and full files mix freely in one context; the block of an
+// outline is marked outline="true". a file that does not parse is inlined in full.
+
+// goOutlineBody replaces function bodies in outlines.
+const goOutlineBody = " { ... }"
+
+// OutlineReport is what the go outlines saved over the whole context.
+type OutlineReport struct {
+	Files       int `json:"files"`
+	BytesSaved  int `json:"bytesSaved"`
+	TokensSaved int `json:"tokensSaved"`
+}
+
+// outlineSelector decides which files are outlined.
+type outlineSelector struct {
+	all   bool     // "." was requested
+	paths []string // os-specific relative files and directories
+}
+
+// newOutlineSelector normalizes the requested paths; nil is returned when nothing is
+// outlined.
+func newOutlineSelector(paths []string) *outlineSelector {
+	s := &outlineSelector{}
+	for _, p := range paths {
+		switch n := normalizeRelPath(p); n {
+		case "":
+		case ".":
+			s.all = true
+		default:
+			s.paths = append(s.paths, n)
+		}
+	}
+	if !s.all && len(s.paths) == 0 {
+		return nil
+	}
+	return s
+}
+
+// matches reports whether the go file at relPath is outlined.
+func (s *outlineSelector) matches(relPath string) bool {
+	if s == nil || !strings.EqualFold(filepath.Ext(relPath), ".go") {
+		return false
+	}
+	if s.all {
+		return true
+	}
+	for _, p := range s.paths {
+		if relPath == p || strings.HasPrefix(relPath, p+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// goOutline renders the outline of a go source file.
+func goOutline(src string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return "", err
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	// build constraints, license and package doc are kept as written
+	parts := []string{src[:offset(file.Name.End())]}
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	for _, decl := range file.Decls {
+		node, start, end := ast.Node(decl), decl.Pos(), decl.End()
+		fn, isFunc := decl.(*ast.FuncDecl)
+		if isFunc {
+			signature := *fn
+			signature.Body = nil
+			node, end = &signature, fn.Type.End()
+			if fn.Doc != nil {
+				start = fn.Doc.Pos()
+			}
+		} else if gen := decl.(*ast.GenDecl); gen.Doc != nil {
+			start = gen.Doc.Pos()
+		}
+		var buf bytes.Buffer
+		commented := &printer.CommentedNode{Node: node, Comments: commentsBetween(fset, file.Comments, start, end, !isFunc)}
+		if err := cfg.Fprint(&buf, fset, commented); err != nil {
+			return "", err
+		}
+		if isFunc {
+			buf.WriteString(goOutlineBody)
+		}
+		parts = append(parts, strings.TrimRight(buf.String(), "\n"))
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+// commentsBetween returns the comment groups from start to end and, with trailing, a
+// comment on the line of end.
+func commentsBetween(fset *token.FileSet, comments []*ast.CommentGroup, start, end token.Pos, trailing bool) []*ast.CommentGroup {
+	endLine := fset.Position(end).Line
+	var result []*ast.CommentGroup
+	for _, cg := range comments {
+		if cg.Pos() >= start && (cg.End() <= end || trailing && fset.Position(cg.Pos()).Line == endLine) {
+			result = append(result, cg)
+		}
+	}
+	return result
+}
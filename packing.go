@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- token budget packing ---
+
+// approxbytespertoken is the bytes-per-token ratio used for local estimates. exact gemini
+// counts would need an api round trip per file, which is far too slow during generation.
+const approxBytesPerToken = 4
+
+const (
+	omittedBudgetMarker   = "[omitted: budget]"
+	omittedTooLargeMarker = "[file omitted: too large]"
+	omittedNonTextMarker  = "[non-text file content omitted]"
+	// omittedUnavailableMarker stands for an entry of a virtual tree whose content was
+	// not kept, e.g. a tar entry past the buffered total
+	omittedUnavailableMarker = "[file omitted: content not kept]"
+)
+
+// packing reasons reported for files that were not inlined.
+const (
+	omitReasonBudget   = "budget"
+	omitReasonTooLarge = "too large"
+)
+
+// estimatetokens returns the approximate token count of bytecount bytes of text.
+func estimateTokens(byteCount int) int {
+	if byteCount <= 0 {
+		return 0
+	}
+	return (byteCount + approxBytesPerToken - 1) / approxBytesPerToken
+}
+
+// contextfileentry is a non-excluded file found while walking the tree; its content is
+// read only after the tree has been written and the packing plan is known.
+type contextFileEntry struct {
+	relPath     string // os-specific, relative to the root
+	absPath     string
+	rootDir     string // the project or workspace root the file belongs to
+	size        int64
+	modTime     time.Time    // with size, decides whether a cached block is still valid
+	gitStatus   string       // set for changed files when git changes were requested
+	renamedFrom string       // original path of a renamed file, relative to rootDir
+	tree        *virtualTree // set when the root is an archive, see virtualtree.go
+}
+
+// OmittedFile is a file whose content was replaced by a marker.
+type OmittedFile struct {
+	Path            string `json:"path"` // forward slashes, as in the <file> blocks
+	EstimatedTokens int    `json:"estimatedTokens"`
+	Reason          string `json:"reason"`
+}
+
+// PackingReport summarizes how a context was packed into a token budget. the plan is
+// made from the sizes on disk (UsedTokens); what transcoding, redaction, line numbers
+// and the escaping of the format add shows only in the written context, which is
+// measured afterwards (WrittenTokens, and OverBudgetTokens when it does not fit).
+type PackingReport struct {
+	TokenBudget      int           `json:"tokenBudget"`
+	TreeTokens       int           `json:"treeTokens"`
+	UsedTokens       int           `json:"usedTokens"`
+	WrittenTokens    int           `json:"writtenTokens"`
+	OverBudgetTokens int           `json:"overBudgetTokens,omitempty"`
+	IncludedFiles    int           `json:"includedFiles"`
+	Omitted          []OmittedFile `json:"omitted"`
+}
+
+// measure records the size of the written context against the budget.
+func (r *PackingReport) measure(writtenBytes int64) {
+	r.WrittenTokens = estimateTokens(int(writtenBytes))
+	r.OverBudgetTokens = max(r.WrittenTokens-r.TokenBudget, 0)
+}
+
+// plantokenbudget decides which files are inlined when opts.tokenbudget is set. the tree
+// always stays; every file starts out as a marker block and is upgraded to its full
+// content in priority order for as long as the estimate stays within the budget:
+//
+//  1. pinned files (in the order they were pinned), smallest first within a pin
+//  2. files living next to a pinned file, smallest first
+//  3. everything else, smallest first
+//
+// the returned map holds the reason for every file that must not be inlined.
+func planTokenBudget(files []contextFileEntry, treeBytes int, opts ContextOptions, formatter contextFormatter) (map[string]string, *PackingReport, error) {
+	report := &PackingReport{TokenBudget: opts.TokenBudget, TreeTokens: estimateTokens(treeBytes), Omitted: []OmittedFile{}}
+	if report.TreeTokens > opts.TokenBudget {
+		return nil, report, fmt.Errorf("%w: the project tree alone needs ~%d tokens but the budget is %d", ErrContextTooLong, report.TreeTokens, opts.TokenBudget)
+	}
+
+	omit := make(map[string]string, len(files))
+	usedBytes := treeBytes
+	var candidates []contextFileEntry
+	for _, f := range files {
+		slashPath := filepath.ToSlash(f.relPath)
+		stub := contextFileBlock{Path: slashPath, Language: languageForPath(slashPath)}
+		if f.gitStatus != "" {
+			stub.Attrs = []fileAttr{{Name: "git-status", Value: f.gitStatus}}
+		}
+		if opts.LineNumbers {
+			stub.Attrs = append(stub.Attrs, fileAttr{Name: "line-numbers", Value: "true"})
+		}
+		overhead := formatter.blockOverhead(stub)
+		if f.size > maxFileReadSizeBytes {
+			if n := excerptLineCount(opts); n > 0 {
+				omit[f.relPath] = omitReasonExcerpt
+				usedBytes += overhead + estimatedExcerptBytes(f.size, n)
+			} else {
+				omit[f.relPath] = omitReasonTooLarge
+				usedBytes += overhead + len(omittedTooLargeMarker)
+			}
+			continue
+		}
+		omit[f.relPath] = omitReasonBudget
+		usedBytes += overhead + len(omittedBudgetMarker)
+		candidates = append(candidates, f)
+	}
+	if estimateTokens(usedBytes) > opts.TokenBudget {
+		return nil, report, fmt.Errorf("%w: the tree plus one marker or excerpt per file needs ~%d tokens but the budget is %d", ErrContextTooLong, estimateTokens(usedBytes), opts.TokenBudget)
+	}
+
+	var pins []string
+	for _, p := range opts.PinnedPaths {
+		if n := normalizeRelPath(p); n != "" && n != "." {
+			pins = append(pins, n)
+		}
+	}
+	pinnedDirs := make(map[string]bool)
+	rank := func(relPath string) int {
+		for i, pin := range pins {
+			if relPath == pin || strings.HasPrefix(relPath, pin+string(os.PathSeparator)) {
+				return i
+			}
+		}
+		if pinnedDirs[filepath.Dir(relPath)] {
+			return len(pins)
+		}
+		return len(pins) + 1
+	}
+	for _, f := range candidates {
+		if rank(f.relPath) < len(pins) {
+			pinnedDirs[filepath.Dir(f.relPath)] = true
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := rank(candidates[i].relPath), rank(candidates[j].relPath)
+		if ri != rj {
+			return ri < rj
+		}
+		if candidates[i].size != candidates[j].size {
+			return candidates[i].size < candidates[j].size
+		}
+		return candidates[i].relPath < candidates[j].relPath
+	})
+
+	budgetBytes := opts.TokenBudget * approxBytesPerToken
+	for _, f := range candidates {
+		size := f.size
+		if opts.LineNumbers {
+			size = numberedSizeEstimate(size)
+		}
+		delta := int(size) - len(omittedBudgetMarker)
+		if usedBytes+delta > budgetBytes {
+			continue // a smaller file further down the list may still fit
+		}
+		usedBytes += delta
+		delete(omit, f.relPath)
+		report.IncludedFiles++
+	}
+	report.UsedTokens = estimateTokens(usedBytes)
+
+	for _, f := range files {
+		if reason, ok := omit[f.relPath]; ok {
+			report.Omitted = append(report.Omitted, OmittedFile{
+				Path:            filepath.ToSlash(f.relPath),
+				EstimatedTokens: estimateTokens(int(f.size)),
+				Reason:          reason,
+			})
+		}
+	}
+	return omit, report, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goruntime "runtime"
+	"sync"
+)
+
+// --- parallel file reading ---
+
+// reading files one after another is slow on network and encrypted disks, so context
+// generation loads file contents on a small worker pool. results are still consumed in
+// tree order, which keeps the output byte-identical to a sequential run.
+
+// contextreadaheadfiles bounds how many loaded files may wait for the writer, so memory
+// stays flat no matter how large the project is.
+const contextReadAheadFiles = 64
+
+// contextreadworkers returns the size of the read pool: i/o bound, so a few more
+// goroutines than cpus, but not so many that a spinning disk starts thrashing.
+func contextReadWorkers() int {
+	return min(max(2*goruntime.NumCPU(), 4), 16)
+}
+
+// forEachOrdered calls load(i) for i in [0, n) on up to workers goroutines and hands
+// the results to consume strictly in index order. at most window results are buffered
+// ahead of consume. it stops early when ctx is cancelled or consume returns an error.
+func forEachOrdered[T any](ctx context.Context, n, workers, window int, load func(i int) T, consume func(i int, v T) error) error {
+	if n == 0 {
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	type job struct {
+		index int
+		out   chan T
+	}
+	jobs := make(chan job)
+	slots := make(chan chan T, window)
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() { // producer: reserves an ordered slot, then hands the job to a worker
+		defer wg.Done()
+		defer close(slots)
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			out := make(chan T, 1) // buffered so a worker never blocks on an abandoned slot
+			select {
+			case slots <- out:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- job{index: i, out: out}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.out <- load(j.index)
+			}
+		}()
+	}
+
+	i := 0
+	for out := range slots {
+		var v T
+		select {
+		case v = <-out:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := consume(i, v); err != nil {
+			return err
+		}
+		i++
+	}
+	if i < n {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// loadedfile is what the read pool hands to the ordered writer.
+type loadedFile struct {
+	block        contextFileBlock
+	secrets      []secretMatch // detected in block.content, replaced by the writer
+	diffSecrets  []secretMatch // detected in block.diff
+	transforms   []transformResult
+	outlined     bool           // block.content is a go outline of the file
+	outlineSave  int            // bytes the outline saved
+	notebook     bool           // block.content is a flattened notebook, see notebook.go
+	notebookSave int            // bytes flattening the notebook saved
+	excerpt      *ExcerptedFile // set for oversized files that were excerpted
+	inlined      bool           // block.content is the text of the file
+	fileLines    bool           // block.content still has the lines of the file, so they can be numbered
+	cached       bool           // taken from the content cache instead of the disk
+	hash         contentHash    // of an inlined block.content, see dedup.go
+}
+
+// fileBody is the body of one <file> block as loaded from disk.
+type fileBody struct {
+	text    string
+	inlined bool       // text is the whole content of the file, so transforms may apply
+	attrs   []fileAttr // e.g. the original encoding of transcoded text
+	excerpt *ExcerptedFile
+}
+
+// loadcontextfile produces the body of one <file> block: the marker for files left out
+// by the packing plan, an excerpt (or the too-large marker) for oversized files,
+// otherwise the file content or a placeholder for binary data. text in a legacy
+// encoding is transcoded to utf-8 (see encoding.go). excerptLines is the number of
+// lines kept at each end of an oversized file, 0 to leave such files out. it runs on
+// the read pool, so it must not touch shared state.
+func (a *App) loadContextFile(f contextFileEntry, omitReason string, excerptLines int) fileBody {
+	switch {
+	case omitReason == omitReasonBudget:
+		return fileBody{text: omittedBudgetMarker}
+	case f.size > maxFileReadSizeBytes:
+		// oversized files are streamed for an excerpt and never read whole
+		if excerptLines > 0 {
+			if text, attrs, info, ok := a.loadExcerpt(f, excerptLines); ok {
+				return fileBody{text: text, attrs: attrs, excerpt: info}
+			}
+		}
+		return fileBody{text: omittedTooLargeMarker}
+	}
+	content, err := f.readFile()
+	if errors.Is(err, errVirtualContentUnavailable) {
+		return fileBody{text: omittedUnavailableMarker}
+	}
+	if err != nil {
+		a.logWarningf("buildshotguntreerecursive: error reading file %s: %v", f.absPath, err)
+		return fileBody{text: fmt.Sprintf("error reading file: %v", err)}
+	}
+	if !isTextContent(content) {
+		if text, encoding, ok := decodeLegacyText(content); ok {
+			return fileBody{text: text, inlined: true, attrs: []fileAttr{{Name: "encoding", Value: encoding}}}
+		}
+		return fileBody{text: omittedNonTextMarker}
+	}
+	return fileBody{text: string(content), inlined: true}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- secret detection and redaction ---
+
+// every inlined file is scanned for secrets before it is written to the context. the scan
+// runs on the read pool and only reports matches; the placeholders are assigned by a
+// single secretRedactor in tree order, so the same secret gets the same placeholder
+// everywhere in a context and across regenerations of the same tree.
+
+// secret kinds reported in the findings and used in the placeholders.
+const (
+	secretKindPrivateKey  = "private-key"
+	secretKindAWSKeyID    = "aws-access-key-id"
+	secretKindAWSSecret   = "aws-secret-access-key"
+	secretKindGoogleKey   = "google-api-key"
+	secretKindGitHubToken = "github-token"
+	secretKindSlackToken  = "slack-token"
+	secretKindStripeKey   = "stripe-key"
+	secretKindAzureKey    = "azure-storage-key"
+	secretKindAPIKey      = "api-key"
+	secretKindJWT         = "jwt"
+	secretKindDotenv      = "dotenv-value"
+	secretKindCredential  = "credential"
+	secretKindHighEntropy = "high-entropy-string"
+)
+
+// SecretFinding is one redacted secret. its line is the line of the file unless the
+// block was rewritten (a flattened notebook, an outline, a transform that removed lines).
+type SecretFinding struct {
+	Kind        string `json:"kind"`
+	Line        int    `json:"line"` // 1-based line in the block as written
+	Placeholder string `json:"placeholder"`
+}
+
+// FileSecretFindings lists the secrets redacted from one file.
+type FileSecretFindings struct {
+	Path     string          `json:"path"` // forward slashes, as in the <file> blocks
+	Findings []SecretFinding `json:"findings"`
+}
+
+// SecretsReport summarizes the redaction of a context.
+type SecretsReport struct {
+	Redacted int                  `json:"redacted"` // total number of replaced occurrences
+	Files    []FileSecretFindings `json:"files"`
+}
+
+// secretMatch is a detected secret: content[start:end] is replaced by a placeholder.
+type secretMatch struct {
+	start, end int
+	kind       string
+}
+
+// secretPattern finds one kind of secret. group selects the submatch that is the
+// secret itself (0 for the whole match), so surrounding syntax stays readable.
+type secretPattern struct {
+	kind  string
+	re    *regexp.Regexp
+	group int
+}
+
+var secretPatterns = []secretPattern{
+	{secretKindPrivateKey, regexp.MustCompile(`-----BEGIN[A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END[A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`), 0},
+	{secretKindAWSKeyID, regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b`), 0},
+	{secretKindAWSSecret, regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|sk).{0,20}?[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`), 1},
+	{secretKindGoogleKey, regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`), 0},
+	{secretKindGitHubToken, regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`), 0},
+	{secretKindSlackToken, regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`), 0},
+	{secretKindStripeKey, regexp.MustCompile(`\b(?:sk|rk)_(?:live|test)_[A-Za-z0-9]{20,}\b`), 0},
+	{secretKindAzureKey, regexp.MustCompile(`(?i)AccountKey=([A-Za-z0-9+/]{40,}={0,2})`), 1},
+	{secretKindAPIKey, regexp.MustCompile(`\bsk-(?:proj-|ant-[a-z0-9]+-)?[A-Za-z0-9_\-]{32,}\b`), 0},
+	{secretKindJWT, regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{16,}`), 0},
+}
+
+// credentialAssignment matches a quoted value assigned to a name that suggests a secret,
+// e.g. password = "..." or "apiToken": "...". the value is group 1.
+var credentialAssignment = regexp.MustCompile(`(?i)[\w.-]*(?:secret|token|passw(?:or)?d|pwd|api[_-]?key|access[_-]?key|private[_-]?key|credential)[\w.-]*["']?\s*(?::=|=>|[:=])\s*["']([^"'\s]{8,})["']`)
+
+// quotedToken matches quoted strings made of base64/base64url characters, the candidates
+// for the entropy check.
+var quotedToken = regexp.MustCompile(`["']([A-Za-z0-9+/=_\-]{32,})["']`)
+
+// dotenvLine matches KEY=value lines; the value is group 2.
+var dotenvLine = regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?([A-Za-z_][A-Za-z0-9_.-]*)[ \t]*=[ \t]*(.*?)[ \t]*\r?$`)
+
+// entropy checks skip lock files: they are full of integrity hashes.
+var secretEntropyExemptFiles = map[string]bool{
+	"go.sum": true, "package-lock.json": true, "yarn.lock": true, "pnpm-lock.yaml": true,
+	"cargo.lock": true, "poetry.lock": true, "composer.lock": true, "gemfile.lock": true,
+}
+
+// isDotenvFile reports whether the file holds KEY=value pairs whose values are all
+// treated as secrets: .env, .env.local, production.env, ... but not examples.
+func isDotenvFile(slashPath string) bool {
+	base := strings.ToLower(path.Base(slashPath))
+	for _, suffix := range []string{".example", ".sample", ".template", ".dist"} {
+		if strings.HasSuffix(base, suffix) {
+			return false
+		}
+	}
+	return base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env")
+}
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// looksRandom is the high-entropy heuristic: mixed case letters and digits with an
+// entropy close to that of random base64.
+func looksRandom(s string) bool {
+	if strings.HasPrefix(s, "sha1-") || strings.HasPrefix(s, "sha256-") || strings.HasPrefix(s, "sha384-") || strings.HasPrefix(s, "sha512-") {
+		return false // subresource integrity hashes
+	}
+	var upper, lower, digit bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit && shannonEntropy(s) >= 4.2
+}
+
+// credentialPlaceholderWords mark documentation values such as "your-api-key".
+var credentialPlaceholderWords = []string{"your", "example", "changeme", "placeholder", "xxxx", "dummy", "redacted"}
+
+// looksLikeCredential filters the values of secret-looking assignments: identifiers and
+// words ("private-key", "geminiApiKey"), template references ("${TOKEN}") and
+// documentation placeholders are not secrets; anything with a digit or symbol is.
+func looksLikeCredential(value string) bool {
+	if strings.HasPrefix(value, "${") || strings.HasPrefix(value, "{{") || strings.HasPrefix(value, "%") {
+		return false
+	}
+	lower := strings.ToLower(value)
+	for _, word := range credentialPlaceholderWords {
+		if strings.Contains(lower, word) {
+			return false
+		}
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_' || c == '.') {
+			return true
+		}
+	}
+	return false
+}
+
+// detectSecrets returns the non-overlapping secrets in content, sorted by position.
+func detectSecrets(slashPath, content string) []secretMatch {
+	var matches []secretMatch
+	addGroup := func(kind string, loc []int, group int) {
+		if len(loc) > 2*group+1 && loc[2*group] >= 0 && loc[2*group+1] > loc[2*group] {
+			matches = append(matches, secretMatch{start: loc[2*group], end: loc[2*group+1], kind: kind})
+		}
+	}
+
+	for _, p := range secretPatterns {
+		for _, loc := range p.re.FindAllStringSubmatchIndex(content, -1) {
+			addGroup(p.kind, loc, p.group)
+		}
+	}
+	if isDotenvFile(slashPath) {
+		for _, loc := range dotenvLine.FindAllStringSubmatchIndex(content, -1) {
+			start, end := loc[4], loc[5]
+			if end-start >= 2 && (content[start] == '"' || content[start] == '\'') && content[end-1] == content[start] {
+				start, end = start+1, end-1 // keep the quotes
+			}
+			addGroup(secretKindDotenv, []int{start, end}, 0)
+		}
+	}
+	for _, loc := range credentialAssignment.FindAllStringSubmatchIndex(content, -1) {
+		if looksLikeCredential(content[loc[2]:loc[3]]) {
+			addGroup(secretKindCredential, loc, 1)
+		}
+	}
+	if !secretEntropyExemptFiles[strings.ToLower(path.Base(slashPath))] {
+		for _, loc := range quotedToken.FindAllStringSubmatchIndex(content, -1) {
+			if looksRandom(content[loc[2]:loc[3]]) {
+				addGroup(secretKindHighEntropy, loc, 1)
+			}
+		}
+	}
+
+	// earlier and longer matches win; the pattern order above decides between equal spans
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+	kept := matches[:0]
+	lastEnd := -1
+	for _, m := range matches {
+		if m.start >= lastEnd {
+			kept = append(kept, m)
+			lastEnd = m.end
+		}
+	}
+	return kept
+}
+
+// secretRedactor replaces detected secrets with numbered placeholders. it is used from
+// the ordered writer only, so it needs no locking.
+type secretRedactor struct {
+	placeholders map[string]string // secret value -> placeholder
+	counter      int
+	report       *SecretsReport
+}
+
+func newSecretRedactor() *secretRedactor {
+	return &secretRedactor{placeholders: make(map[string]string), report: &SecretsReport{Files: []FileSecretFindings{}}}
+}
+
+// redact applies matches (from detectSecrets on the same content) and returns the
+// findings for record. a placeholder keeps the line breaks of a multi-line secret such
+// as a private key block, so the lines after it keep their numbers.
+func (r *secretRedactor) redact(content string, matches []secretMatch) (string, []SecretFinding) {
+	if len(matches) == 0 {
+		return content, nil
+	}
+	var sb strings.Builder
+	sb.Grow(len(content))
+	findings := make([]SecretFinding, 0, len(matches))
+	line, scanned, prev := 1, 0, 0
+	for _, m := range matches {
+		line += strings.Count(content[scanned:m.start], "\n")
+		scanned = m.start
+
+		value := content[m.start:m.end]
+		placeholder, ok := r.placeholders[value]
+		if !ok {
+			r.counter++
+			placeholder = "[redacted:" + m.kind + ":" + strconv.Itoa(r.counter) + "]"
+			r.placeholders[value] = placeholder
+		}
+		sb.WriteString(content[prev:m.start])
+		sb.WriteString(placeholder)
+		sb.WriteString(lineBreaks(value))
+		prev = m.end
+		findings = append(findings, SecretFinding{Kind: m.kind, Line: line, Placeholder: placeholder})
+	}
+	sb.WriteString(content[prev:])
+	return sb.String(), findings
+}
+
+// record reports the findings of a block that is written to the context; blocks left
+// out of a delta as unchanged are redacted but not reported.
+func (r *secretRedactor) record(slashPath string, findings []SecretFinding) {
+	if len(findings) == 0 {
+		return
+	}
+	r.report.Redacted += len(findings)
+	r.report.Files = append(r.report.Files, FileSecretFindings{Path: slashPath, Findings: findings})
+}
+
+// lineBreaks returns the line endings of s in order, e.g. "\n\n" for a three-line value.
+func lineBreaks(s string) string {
+	if !strings.Contains(s, "\n") {
+		return ""
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] != '\n':
+		case i > 0 && s[i-1] == '\r':
+			sb.WriteString("\r\n")
+		default:
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
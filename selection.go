@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- file selection for context generation ---
+
+// a file ends up in the context when it passes these checks, in this order:
+//
+//  1. excludedpaths: an explicitly excluded file or directory (and everything below it)
+//     is always left out, whatever the include patterns say
+//  2. include patterns: when any are given, only files they select are inlined
+//  3. changed files mode: only files git reports as changed (and, optionally, the other
+//     files in their directories) are inlined
+//
+// left out files still appear in the tree, marked [excluded], [not included] or
+// [unchanged].
+
+const (
+	treeMarkerExcluded    = " [excluded]"
+	treeMarkerNotIncluded = " [not included]"
+	treeMarkerUnchanged   = " [unchanged]"
+)
+
+// includematcher evaluates gitignore-style include globs ("internal/billing/**",
+// "cmd/api/*.go", "!**/*_test.go"). patterns are relative to the root; like in a
+// .gitignore, a pattern with a slash is anchored, one without matches the name at any
+// depth, a trailing slash only matches directories and "!" negates.
+type includeMatcher struct {
+	rules []ignoreRule
+}
+
+// newincludematcher compiles patterns; blank lines and # comments are skipped.
+func newIncludeMatcher(patterns []string) (*includeMatcher, error) {
+	m := &includeMatcher{}
+	for _, p := range patterns {
+		trimmed := strings.TrimSpace(p)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		rule, ok := parseIgnoreLine(filepath.ToSlash(trimmed), "")
+		if !ok {
+			return nil, fmt.Errorf("invalid include pattern %q", p)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	if len(m.rules) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// includes reports whether relpath is selected. the path itself is checked first, then
+// its parent directories from the nearest up; the last rule matching the first level
+// that matches at all decides. so "web/" selects everything below web, "!web/dist/"
+// drops a part of it again and "web/dist/keep.js" brings one file back.
+func (m *includeMatcher) includes(relPath string, isDir bool) bool {
+	if m == nil {
+		return true
+	}
+	p := filepath.ToSlash(relPath)
+	for {
+		matched, included := false, false
+		for _, r := range m.rules {
+			if r.matches(p, isDir) {
+				matched, included = true, !r.negate
+			}
+		}
+		if matched {
+			return included
+		}
+		slash := strings.LastIndex(p, "/")
+		if slash < 0 {
+			return false
+		}
+		p, isDir = p[:slash], true
+	}
+}
+
+// contextselection decides which walked entries are inlined into a context.
+type contextSelection struct {
+	excluded map[string]bool // explicitly excluded relative paths
+	include  *includeMatcher // nil when no include patterns were given
+
+	// git changes relative to the base ref; set in changed files mode and for diffs
+	changes      map[string]string
+	renamedFrom  map[string]string // renamed file -> original path relative to its root
+	changedOnly  bool
+	neighborDirs map[string]bool // directories whose unchanged files are inlined too
+}
+
+func newContextSelection(ctx context.Context, roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) (*contextSelection, error) {
+	include, err := newIncludeMatcher(opts.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	s := &contextSelection{excluded: make(map[string]bool, len(excludedPaths)), include: include}
+	for _, p := range excludedPaths {
+		s.excluded[p] = true
+	}
+
+	if opts.ChangedOnly || opts.IncludeDiffs {
+		// every root has its own repository; the changes are keyed by context path
+		s.changes, s.renamedFrom = make(map[string]string), make(map[string]string)
+		for _, root := range roots {
+			if root.Ref != "" { // the changes are those of the working tree
+				if isWorkspace(roots) {
+					continue
+				}
+				return nil, fmt.Errorf("changed files and diffs are not available for a project read at git ref %s", root.Ref)
+			}
+			changes, renamedFrom, err := gitChangedFiles(ctx, root.Path, opts.BaseRef)
+			if errors.Is(err, errNotAGitRepository) && isWorkspace(roots) {
+				continue // a plain folder next to repositories has no changes
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list changed files: %w", err)
+			}
+			for relPath, status := range changes {
+				s.changes[root.prefixed(relPath)] = status
+			}
+			for relPath, origPath := range renamedFrom {
+				s.renamedFrom[root.prefixed(relPath)] = origPath
+			}
+		}
+		s.changedOnly = opts.ChangedOnly
+		if opts.ChangedOnly && opts.IncludeNeighbors {
+			s.neighborDirs = make(map[string]bool)
+			for relPath := range s.changes {
+				s.neighborDirs[filepath.Dir(relPath)] = true
+			}
+		}
+	}
+	return s, nil
+}
+
+// treemarker returns the tree suffix of an entry whose parent is not excluded: the
+// excluded marker, the not-included marker for files outside the include patterns, the
+// unchanged marker for files left out by the changed files mode, or "" for entries that
+// are walked and inlined. directories are never marked as not included or unchanged
+// since files below them may still be selected.
+func (s *contextSelection) treeMarker(relPath string, isDir bool) string {
+	if s.excluded[relPath] {
+		return treeMarkerExcluded
+	}
+	if isDir {
+		return ""
+	}
+	if !s.include.includes(relPath, false) {
+		return treeMarkerNotIncluded
+	}
+	if s.changedOnly && s.changes[relPath] == "" && !s.neighborDirs[filepath.Dir(relPath)] {
+		return treeMarkerUnchanged
+	}
+	return ""
+}
+
+// gitstatus returns the git status of a changed file, "" when unchanged or unknown.
+func (s *contextSelection) gitStatus(relPath string) string {
+	return s.changes[relPath]
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
-
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // --- shotgun diff splitting ---
@@ -15,11 +13,13 @@ import (
 // smaller git diff strings, each not exceeding approxlinelimit lines.
 // it tries to split between file diffs first, then between chunks if a single file diff is too large.
 func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]string, error) {
-	runtime.LogInfof(a.ctx, "splitshotgundiff called with line limit: %d for git diff text", approxLineLimit)
+	a.logInfof("splitshotgundiff called with line limit: %d for git diff text", approxLineLimit)
 
 	if strings.TrimSpace(gitDiffText) == "" {
 		return []string{}, nil
 	}
+	// a model answering a line-numbered context may copy the numbers into its hunks
+	gitDiffText = stripDiffLineNumbers(gitDiffText)
 
 	// regex to find the start of a file diff block.
 	// go's regex engine (re2) does not support lookarounds like (?=).
@@ -31,7 +31,7 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 
 	if len(startIndices) == 0 {
 		// if no "diff --git" is found, treat the whole input as a single block
-		runtime.LogWarning(a.ctx, fmt.Sprintf("splitshotgundiff: no 'diff --git' blocks found in input. treating as single block."))
+		a.logWarning(fmt.Sprintf("splitshotgundiff: no 'diff --git' blocks found in input. treating as single block."))
 		if strings.TrimSpace(gitDiffText) != "" {
 			fileDiffBlocks = append(fileDiffBlocks, gitDiffText)
 		}
@@ -84,7 +84,7 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 			}
 
 			if firstHunkIndex == -1 { // no chunks found, but block is large? unusual. treat as one large piece.
-				runtime.LogWarning(a.ctx, fmt.Sprintf("splitshotgundiff: large file block without chunks in '%s'. treating as single block.", getPathFromDiffHeader(fileBlockLines[0])))
+				a.logWarning(fmt.Sprintf("splitshotgundiff: large file block without chunks in '%s'. treating as single block.", getPathFromDiffHeader(fileBlockLines[0])))
 				splitDiffs = append(splitDiffs, fileBlock+"\n") // add newline for consistency if it's a full block
 				continue
 			}
@@ -161,21 +161,21 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 	// --- advanced merging logic ---
 	// if approxlinelimit is not positive, merging logic is skipped.
 	if approxLineLimit <= 0 {
-		runtime.LogInfof(a.ctx, "approxlinelimit is %d, skipping merge step. returning %d initial splits.", approxLineLimit, len(initialSplitDiffs))
+		a.logInfof("approxlinelimit is %d, skipping merge step. returning %d initial splits.", approxLineLimit, len(initialSplitDiffs))
 		return initialSplitDiffs, nil
 	}
 
 	// if there's 0 or 1 split, no merging is possible or needed.
 	if len(initialSplitDiffs) <= 1 {
-		runtime.LogInfof(a.ctx, "only %d initial split(s), no merging needed. returning as is.", len(initialSplitDiffs))
+		a.logInfof("only %d initial split(s), no merging needed. returning as is.", len(initialSplitDiffs))
 		return initialSplitDiffs, nil
 	}
 
-	runtime.LogInfof(a.ctx, "starting advanced merge step for %d initial splits with approxlinelimit %d.", len(initialSplitDiffs), approxLineLimit)
+	a.logInfof("starting advanced merge step for %d initial splits with approxlinelimit %d.", len(initialSplitDiffs), approxLineLimit)
 
 	// allow merged splits to be up to 20% larger than the user's approximate line limit.
 	maxAllowedLines := int(float64(approxLineLimit) * 1.20)
-	runtime.LogInfof(a.ctx, "max allowed lines per merged split: %d", maxAllowedLines)
+	a.logInfof("max allowed lines per merged split: %d", maxAllowedLines)
 
 	// this is a modified bin packing problem approach:
 	// 1. initialize splitstomerge list with initial splits
@@ -197,7 +197,7 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 				Splits:    []string{initialSplitDiffs[i]},
 				LineCount: size,
 			})
-			runtime.LogInfof(a.ctx, "split %d with %d lines kept as standalone group (already large)", i, size)
+			a.logInfof("split %d with %d lines kept as standalone group (already large)", i, size)
 		} else {
 			smallSplits = append(smallSplits, i)
 		}
@@ -205,7 +205,7 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 
 	// if no small splits, return the identified large splits as-is
 	if len(smallSplits) == 0 {
-		runtime.LogInfof(a.ctx, "no small splits to merge, returning %d large splits as-is", len(largeSplits))
+		a.logInfof("no small splits to merge, returning %d large splits as-is", len(largeSplits))
 		result := make([]string, len(largeSplits))
 		for i, group := range largeSplits {
 			result[i] = group.Splits[0] // each large split is its own group with one split
@@ -327,13 +327,13 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 		// remove group j
 		currentSolution = append(currentSolution[:j], currentSolution[j+1:]...)
 
-		runtime.LogInfof(a.ctx, "merged two groups, solution now has %d groups with score %.2f",
+		a.logInfof("merged two groups, solution now has %d groups with score %.2f",
 			len(currentSolution), bestMerge.NewScore)
 	}
 
 	// combine the large splits and the optimized small splits
 	finalGroups := append(largeSplits, currentSolution...)
-	runtime.LogInfof(a.ctx, "final solution: %d groups (%d large, %d optimized small groups)",
+	a.logInfof("final solution: %d groups (%d large, %d optimized small groups)",
 		len(finalGroups), len(largeSplits), len(currentSolution))
 
 	// build the final result strings
@@ -346,10 +346,10 @@ func (a *App) SplitShotgunDiff(gitDiffText string, approxLineLimit int) ([]strin
 			// multiple splits, join with newlines
 			mergedSplitsResult[i] = strings.Join(group.Splits, "\n")
 		}
-		runtime.LogInfof(a.ctx, "group %d: %d splits, %d lines", i, len(group.Splits), group.LineCount)
+		a.logInfof("group %d: %d splits, %d lines", i, len(group.Splits), group.LineCount)
 	}
 
-	runtime.LogInfof(a.ctx, "split git diff: %d initial splits, merged into %d final splits. target line limit ~%d (merged max %d).",
+	a.logInfof("split git diff: %d initial splits, merged into %d final splits. target line limit ~%d (merged max %d).",
 		len(initialSplitDiffs), len(mergedSplitsResult), approxLineLimit, maxAllowedLines)
 	return mergedSplitsResult, nil
 }
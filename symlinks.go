@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- symbolic links ---
+
+// every walk (the file list, the progress count and the context generator) reads
+// directories through symlinkWalker.readDir, so all of them agree on what a link is:
+//
+//   - skip: links are left out entirely
+//   - show (default): links are listed with their target but never read or descended
+//   - follow: links whose target resolves inside the root are treated like the target;
+//     links pointing outside the root, dangling links and links back to a directory
+//     that is already being walked (a cycle) are listed but not followed
+
+const (
+	symlinkPolicySkip   = "skip"
+	symlinkPolicyShow   = "show"
+	symlinkPolicyFollow = "follow"
+
+	defaultSymlinkPolicy = symlinkPolicyShow
+)
+
+// why a link under the follow policy is listed but not followed.
+const (
+	symlinkNoteOutsideRoot = "outside root"
+	symlinkNoteBroken      = "broken link"
+	symlinkNoteCycle       = "cycle"
+)
+
+// validateSymlinkPolicy returns the policy to use for p ("" means the default).
+func validateSymlinkPolicy(p string) (string, error) {
+	switch p {
+	case "":
+		return defaultSymlinkPolicy, nil
+	case symlinkPolicySkip, symlinkPolicyShow, symlinkPolicyFollow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy %q (supported: skip, show, follow)", p)
+}
+
+// walkEntry is a directory entry after the symlink policy has been applied.
+type walkEntry struct {
+	name  string
+	path  string // path below the walked root, through links
+	isDir bool   // for links: whether the target is a directory
+
+	isLink     bool
+	linkTarget string // as stored in the link
+	linkNote   string // set when a link is not followed under the follow policy
+	follow     bool   // read or descend through the entry; always true for non-links
+
+	entry os.DirEntry
+	info  os.FileInfo // stat of the link target, nil for non-links
+}
+
+// stat returns the size and modification time of the file the entry stands for,
+// zero values when unknown.
+func (e walkEntry) stat() (int64, time.Time) {
+	if e.info != nil {
+		return e.info.Size(), e.info.ModTime()
+	}
+	if fi, err := e.entry.Info(); err == nil {
+		return fi.Size(), fi.ModTime()
+	}
+	return 0, time.Time{}
+}
+
+// treeLabel is the name as shown in trees: links get " -> target" and the reason
+// they were not followed.
+func (e walkEntry) treeLabel() string {
+	if !e.isLink {
+		return e.name
+	}
+	label := e.name + " -> " + e.linkTarget
+	if e.linkNote != "" {
+		label += " [" + e.linkNote + "]"
+	}
+	return label
+}
+
+// symlinkWalker reads directories below rootDir according to a symlink policy and
+// leaves out the hard-excluded directories (see hardexclude.go).
+type symlinkWalker struct {
+	policy     string
+	rootDir    string
+	rootReal   string          // rootdir with its own links resolved, for the inside-the-root check
+	hiddenDirs map[string]bool // hard-excluded directory names
+	tree       *virtualTree    // set when the root is not a folder, see virtualtree.go
+}
+
+func newSymlinkWalker(rootDir, policy string, hiddenDirs map[string]bool) (*symlinkWalker, error) {
+	policy, err := validateSymlinkPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	w := &symlinkWalker{policy: policy, rootDir: rootDir, rootReal: rootDir, hiddenDirs: hiddenDirs}
+	if real, err := filepath.EvalSymlinks(rootDir); err == nil {
+		w.rootReal = real
+	}
+	return w, nil
+}
+
+// readDir lists dirPath (a directory below the root, possibly reached through links).
+// the entries come back in directory order, without hard-excluded directories, so the
+// walks never see them (and the last entry of a tree level is always a shown one).
+func (w *symlinkWalker) readDir(dirPath string) ([]walkEntry, error) {
+	if w.tree != nil {
+		return w.readVirtualDir(dirPath)
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]walkEntry, 0, len(entries))
+	for _, entry := range entries {
+		e := walkEntry{name: entry.Name(), path: filepath.Join(dirPath, entry.Name()), entry: entry}
+		if entry.Type()&os.ModeSymlink == 0 {
+			e.isDir = entry.IsDir()
+			e.follow = true
+			if !(e.isDir && w.hiddenDirs[e.name]) {
+				result = append(result, e)
+			}
+			continue
+		}
+		if w.policy == symlinkPolicySkip {
+			continue
+		}
+		e.isLink = true
+		e.linkTarget, _ = os.Readlink(e.path)
+		e.linkTarget = filepath.ToSlash(e.linkTarget)
+		if info, err := os.Stat(e.path); err == nil {
+			e.info = info
+			e.isDir = info.IsDir()
+		}
+		if e.isDir && w.hiddenDirs[e.name] {
+			continue
+		}
+		if w.policy == symlinkPolicyFollow {
+			w.resolve(&e, dirPath)
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// resolve decides whether a link found in dirPath may be followed.
+func (w *symlinkWalker) resolve(e *walkEntry, dirPath string) {
+	real, err := filepath.EvalSymlinks(e.path)
+	if err != nil || e.info == nil {
+		e.linkNote = symlinkNoteBroken
+		return
+	}
+	if rel, err := filepath.Rel(w.rootReal, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		e.linkNote = symlinkNoteOutsideRoot
+		return
+	}
+	if e.isDir && w.isAncestor(e.info, dirPath) {
+		e.linkNote = symlinkNoteCycle
+		return
+	}
+	e.follow = true
+}
+
+// isAncestor reports whether target is dirPath or one of its parents up to the root,
+// comparing device and inode (os.SameFile), so a cycle is caught whatever path the
+// links took to get here.
+func (w *symlinkWalker) isAncestor(target os.FileInfo, dirPath string) bool {
+	for p := dirPath; ; p = filepath.Dir(p) {
+		if info, err := os.Stat(p); err == nil && os.SameFile(target, info) {
+			return true
+		}
+		if p == w.rootDir || p == filepath.Dir(p) {
+			return false
+		}
+	}
+}
+
+// currentSymlinkPolicy returns the policy from the settings, or the default.
+func (a *App) currentSymlinkPolicy() string {
+	policy, err := validateSymlinkPolicy(a.settings.SymlinkPolicy)
+	if err != nil {
+		return defaultSymlinkPolicy
+	}
+	return policy
+}
+
+// GetSymlinkPolicy returns how symbolic links are handled: "skip", "show" or "follow".
+func (a *App) GetSymlinkPolicy() string {
+	return a.currentSymlinkPolicy()
+}
+
+// SetSymlinkPolicy changes and saves how symbolic links are handled.
+func (a *App) SetSymlinkPolicy(policy string) error {
+	policy, err := validateSymlinkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	a.settings.SymlinkPolicy = policy
+	a.logInfof("app setting symlinkpolicy changed to: %s", policy)
+	if err := a.saveSettings(); err != nil {
+		return fmt.Errorf("failed to save symlink policy: %w", err)
+	}
+	if a.fileWatcher != nil && a.fileWatcher.isWatching() {
+		return a.fileWatcher.RefreshIgnoresAndRescan()
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// --- token-saving content transforms ---
+
+// transforms shrink file contents before they are inlined. they are configured per
+// extension in contextoptions.transforms and always run in the order of
+// contentTransformOrder, whatever order the request lists them in.
+
+const (
+	transformDropLicenseHeader = "drop-license-header"
+	transformStripComments     = "strip-comments"
+	transformTrimTrailingSpace = "trim-trailing-whitespace"
+	transformCollapseBlank     = "collapse-blank-lines"
+)
+
+// transformAllFiles is the contextoptions.transforms key that applies to every file.
+const transformAllFiles = "*"
+
+var contentTransformOrder = []string{
+	transformDropLicenseHeader,
+	transformStripComments,
+	transformTrimTrailingSpace,
+	transformCollapseBlank,
+}
+
+// TransformSaving is what one transform saved over the whole context.
+type TransformSaving struct {
+	Name        string `json:"name"`
+	Files       int    `json:"files"` // files the transform changed
+	BytesSaved  int    `json:"bytesSaved"`
+	TokensSaved int    `json:"tokensSaved"`
+}
+
+// TransformReport lists the savings of every requested transform.
+type TransformReport struct {
+	Savings []TransformSaving `json:"savings"`
+}
+
+// transformResult is the effect of one transform on one file.
+type transformResult struct {
+	name       string
+	bytesSaved int
+}
+
+// contentTransformer knows which transforms apply to which extension.
+type contentTransformer struct {
+	byExt map[string]map[string]bool // lowercased extension (or "*") -> transform names
+}
+
+// newContentTransformer validates the per-extension configuration. extensions may be
+// given with or without the leading dot; nil is returned when nothing is configured.
+func newContentTransformer(config map[string][]string) (*contentTransformer, error) {
+	known := make(map[string]bool, len(contentTransformOrder))
+	for _, name := range contentTransformOrder {
+		known[name] = true
+	}
+	t := &contentTransformer{byExt: make(map[string]map[string]bool)}
+	for ext, names := range config {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != transformAllFiles && ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "" {
+			ext = transformAllFiles
+		}
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !known[name] {
+				return nil, fmt.Errorf("unknown transform %q (supported: %s)", name, strings.Join(contentTransformOrder, ", "))
+			}
+			if t.byExt[ext] == nil {
+				t.byExt[ext] = make(map[string]bool)
+			}
+			t.byExt[ext][name] = true
+		}
+	}
+	if len(t.byExt) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// apply runs the transforms configured for slashPath and reports what each one saved.
+func (t *contentTransformer) apply(slashPath, content string) (string, []transformResult) {
+	if t == nil {
+		return content, nil
+	}
+	ext := strings.ToLower(path.Ext(slashPath))
+	syntax := commentSyntaxFor(ext)
+	var results []transformResult
+	for _, name := range contentTransformOrder {
+		if !t.byExt[ext][name] && !t.byExt[transformAllFiles][name] {
+			continue
+		}
+		var next string
+		switch name {
+		case transformDropLicenseHeader:
+			next = dropLicenseHeader(content, ext, syntax)
+		case transformStripComments:
+			next = stripComments(content, ext, syntax)
+		case transformTrimTrailingSpace:
+			next = trimTrailingWhitespace(content)
+		case transformCollapseBlank:
+			next = collapseBlankLines(content)
+		}
+		if saved := len(content) - len(next); saved > 0 {
+			results = append(results, transformResult{name: name, bytesSaved: saved})
+			content = next
+		}
+	}
+	return content, results
+}
+
+// newReport starts a report listing every requested transform.
+func (t *contentTransformer) newReport() *TransformReport {
+	report := &TransformReport{Savings: []TransformSaving{}}
+	for _, name := range contentTransformOrder {
+		for _, names := range t.byExt {
+			if names[name] {
+				report.Savings = append(report.Savings, TransformSaving{Name: name})
+				break
+			}
+		}
+	}
+	return report
+}
+
+// add accumulates the results of one file.
+func (r *TransformReport) add(results []transformResult) {
+	for _, res := range results {
+		for i := range r.Savings {
+			if r.Savings[i].Name == res.name {
+				r.Savings[i].Files++
+				r.Savings[i].BytesSaved += res.bytesSaved
+				r.Savings[i].TokensSaved = estimateTokens(r.Savings[i].BytesSaved)
+			}
+		}
+	}
+}
+
+// commentSyntax describes the comments of a language family for the generic lexer.
+type commentSyntax struct {
+	line       []string // line comment starters
+	blockStart string
+	blockEnd   string
+	quotes     string // string delimiters; a backslash escapes inside them
+	// lineAfterBlank: a line comment only starts a line or follows a blank, as in
+	// shells, where $#, ${#list[@]} and url#anchor are not comments
+	lineAfterBlank bool
+}
+
+var (
+	cStyleComments      = &commentSyntax{line: []string{"//"}, blockStart: "/*", blockEnd: "*/", quotes: "\"'`"}
+	hashComments        = &commentSyntax{line: []string{"#"}, quotes: "\"'", lineAfterBlank: true}
+	sqlComments         = &commentSyntax{line: []string{"--"}, blockStart: "/*", blockEnd: "*/", quotes: "'\""}
+	cssComments         = &commentSyntax{blockStart: "/*", blockEnd: "*/", quotes: "\"'"}
+	luaComments         = &commentSyntax{line: []string{"--"}, quotes: "\"'"}
+	commentSyntaxByExts = map[string]*commentSyntax{
+		".java": cStyleComments, ".kt": cStyleComments, ".kts": cStyleComments, ".scala": cStyleComments,
+		".swift": cStyleComments, ".rs": cStyleComments, ".c": cStyleComments, ".h": cStyleComments,
+		".cc": cStyleComments, ".cpp": cStyleComments, ".cxx": cStyleComments, ".hpp": cStyleComments,
+		".cs": cStyleComments, ".dart": cStyleComments, ".php": cStyleComments, ".proto": cStyleComments,
+		".groovy": cStyleComments, ".gradle": cStyleComments, ".scss": cStyleComments, ".less": cStyleComments,
+		".py": hashComments, ".rb": hashComments, ".sh": hashComments, ".bash": hashComments,
+		".zsh": hashComments, ".toml": hashComments, ".pl": hashComments, ".r": hashComments,
+		".tf": hashComments, ".ps1": hashComments,
+		".css": cssComments, ".sql": sqlComments, ".lua": luaComments,
+	}
+)
+
+// commentSyntaxFor returns the comment syntax of ext, nil when unknown. go is handled by
+// go/scanner instead. javascript and typescript (regex literals such as /\/*/) and yaml
+// (unquoted values such as https://host/#anchor) cannot be lexed without a parser and
+// are left out, so their comments are kept.
+func commentSyntaxFor(ext string) *commentSyntax {
+	return commentSyntaxByExts[ext]
+}
+
+// commentSpan is a comment found in a file: content[start:end].
+type commentSpan struct {
+	start, end int
+}
+
+// findComments lists the comments of content. for go it uses go/scanner; for other
+// languages a lexer that skips string literals. ok is false when the language is not
+// supported or the source does not lex cleanly (e.g. an unterminated block comment),
+// and the content is then left as it is.
+func findComments(content, ext string, syntax *commentSyntax) (spans []commentSpan, ok bool) {
+	if ext == ".go" {
+		return findGoComments(content)
+	}
+	if syntax == nil {
+		return nil, false
+	}
+	for i := 0; i < len(content); {
+		c := content[i]
+		if strings.IndexByte(syntax.quotes, c) >= 0 {
+			i = skipQuoted(content, i, c)
+			continue
+		}
+		if syntax.blockStart != "" && strings.HasPrefix(content[i:], syntax.blockStart) {
+			end := strings.Index(content[i+len(syntax.blockStart):], syntax.blockEnd)
+			if end < 0 {
+				return nil, false
+			}
+			stop := i + len(syntax.blockStart) + end + len(syntax.blockEnd)
+			spans = append(spans, commentSpan{i, stop})
+			i = stop
+			continue
+		}
+		isLineComment := false
+		if !syntax.lineAfterBlank || i == 0 || strings.IndexByte(" \t\r\n", content[i-1]) >= 0 {
+			for _, starter := range syntax.line {
+				if strings.HasPrefix(content[i:], starter) {
+					isLineComment = true
+					break
+				}
+			}
+		}
+		if isLineComment {
+			if i == 0 && strings.HasPrefix(content, "#!") {
+				i = lineEnd(content, i) // keep the shebang
+				continue
+			}
+			end := lineEnd(content, i)
+			spans = append(spans, commentSpan{i, end})
+			i = end
+			continue
+		}
+		i++
+	}
+	return spans, true
+}
+
+// skipQuoted returns the index after the string literal starting at content[i]. python
+// style triple quotes are treated as one literal.
+func skipQuoted(content string, i int, quote byte) int {
+	delim := string(quote)
+	if quote != '`' && strings.HasPrefix(content[i:], strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	for j := i + len(delim); j < len(content); j++ {
+		switch {
+		case content[j] == '\\' && quote != '`':
+			j++
+		case strings.HasPrefix(content[j:], delim):
+			return j + len(delim)
+		case content[j] == '\n' && len(delim) == 1 && quote != '`':
+			return j // unterminated single-line string: resume after it
+		}
+	}
+	return len(content)
+}
+
+// lineEnd returns the index of the newline ending the line that contains i (or len).
+func lineEnd(content string, i int) int {
+	if nl := strings.IndexByte(content[i:], '\n'); nl >= 0 {
+		return i + nl
+	}
+	return len(content)
+}
+
+// findGoComments uses go/scanner so comment markers inside strings and runes are never
+// mistaken for comments. build constraints and //go: directives are kept.
+func findGoComments(content string) ([]commentSpan, bool) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(content))
+	var s scanner.Scanner
+	failed := false
+	s.Init(file, []byte(content), func(token.Position, string) { failed = true }, scanner.ScanComments)
+	var spans []commentSpan
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT {
+			continue
+		}
+		if strings.HasPrefix(lit, "//go:") || strings.HasPrefix(lit, "// +build") || strings.HasPrefix(lit, "//line ") {
+			continue
+		}
+		start := file.Offset(pos)
+		spans = append(spans, commentSpan{start, start + len(lit)})
+	}
+	if failed {
+		return nil, false
+	}
+	return spans, true
+}
+
+// removeSpans cuts spans out of content. a line that held nothing but comments is
+// removed entirely; otherwise only the comment and the blanks before it go, and a
+// comment right between two tokens is replaced with one space.
+func removeSpans(content string, spans []commentSpan) string {
+	if len(spans) == 0 {
+		return content
+	}
+	var sb strings.Builder
+	sb.Grow(len(content))
+	prev := 0
+	for _, span := range spans {
+		start, end := span.start, span.end
+		lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+		stop := lineEnd(content, end)
+		if lineStart >= prev && strings.TrimSpace(content[lineStart:start]) == "" && strings.TrimSpace(content[end:stop]) == "" {
+			// the comment owns its lines: drop them including the newline
+			sb.WriteString(content[prev:lineStart])
+			if stop < len(content) {
+				stop++
+			}
+			prev = stop
+			continue
+		}
+		sb.WriteString(strings.TrimRight(content[prev:start], " \t"))
+		// a comment between two tokens (var/**/y) leaves a blank, so they stay apart
+		if written := sb.String(); written != "" && !isBlankByte(written[len(written)-1]) && end < len(content) && !isBlankByte(content[end]) {
+			sb.WriteByte(' ')
+		}
+		prev = end
+	}
+	sb.WriteString(content[prev:])
+	return sb.String()
+}
+
+func isBlankByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// stripComments removes all comments of a supported language.
+func stripComments(content, ext string, syntax *commentSyntax) string {
+	spans, ok := findComments(content, ext, syntax)
+	if !ok {
+		return content
+	}
+	return removeSpans(content, spans)
+}
+
+var licenseHeaderWords = regexp.MustCompile(`(?i)\b(copyright|licen[cs]ed?|spdx-license-identifier|all rights reserved)\b`)
+
+// dropLicenseHeader removes the leading comments of a file when they mention a license
+// or copyright; other leading comments (e.g. a go package doc) are kept.
+func dropLicenseHeader(content, ext string, syntax *commentSyntax) string {
+	spans, ok := findComments(content, ext, syntax)
+	if !ok || len(spans) == 0 {
+		return content
+	}
+	// the header is the run of comments at the top separated only by whitespace
+	offset := 0
+	if strings.HasPrefix(content, "#!") {
+		offset = lineEnd(content, 0)
+	}
+	var header []commentSpan
+	for _, span := range spans {
+		if strings.TrimSpace(content[offset:span.start]) != "" {
+			break
+		}
+		header = append(header, span)
+		offset = span.end
+	}
+	if len(header) == 0 {
+		return content
+	}
+	var text strings.Builder
+	for _, span := range header {
+		text.WriteString(content[span.start:span.end])
+	}
+	if !licenseHeaderWords.MatchString(text.String()) {
+		return content
+	}
+	result := removeSpans(content, header)
+	if strings.HasPrefix(content, "#!") {
+		return result
+	}
+	return strings.TrimLeft(result, "\r\n")
+}
+
+// trimTrailingWhitespace removes spaces and tabs at the end of every line.
+func trimTrailingWhitespace(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	for i, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		lines[i] = strings.TrimRight(body, " \t") + line[len(body):]
+	}
+	return strings.Join(lines, "")
+}
+
+// collapseBlankLines replaces runs of blank lines with a single empty line.
+func collapseBlankLines(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	out := lines[:0]
+	blankRun := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" && strings.HasSuffix(line, "\n") {
+			blankRun++
+			if blankRun > 1 {
+				continue
+			}
+			line = line[len(strings.TrimRight(line, "\r\n")):] // keep only the line ending
+		} else {
+			blankRun = 0
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "")
+}
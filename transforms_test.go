@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name, ext, in, want string
+	}{
+		{"go", ".go",
+			"package p\n\n// doc\nvar x = \"//\" // trailing\n//go:generate stringer\n",
+			"package p\n\nvar x = \"//\"\n//go:generate stringer\n"},
+		{"c block and line", ".c", "int x; /* a */ int y; // b\n", "int x; int y;\n"},
+		{"go inline block", ".go", "package p\n\nvar/**/y = 1\n", "package p\n\nvar y = 1\n"},
+		{"c block between tokens", ".c", "int/* a */x = a/**/+b;\n", "int x = a +b;\n"},
+		{"c own line", ".c", "a;\n  // gone\nb;\n", "a;\nb;\n"},
+		{"c url in string", ".c", `s = "http://x"; // c` + "\n", `s = "http://x";` + "\n"},
+		{"c unterminated block", ".c", "int x; /* open\nint y;\n", "int x; /* open\nint y;\n"},
+		{"sql", ".sql", "select 1; -- one\n/* two */\n", "select 1;\n"},
+		{"shell array length", ".sh", "n=${#arr[@]}\n", "n=${#arr[@]}\n"},
+		{"shell argument count", ".sh", "echo $# done # count\n", "echo $# done\n"},
+		{"shell prefix removal", ".sh", "echo ${path#*/}\n", "echo ${path#*/}\n"},
+		{"shebang", ".sh", "#!/bin/sh\n# comment\necho hi\n", "#!/bin/sh\necho hi\n"},
+		{"python string", ".py", "url = \"https://x/#a\"  # note\n", "url = \"https://x/#a\"\n"},
+		{"python triple quotes", ".py", "s = '''\n# not a comment\n'''\n", "s = '''\n# not a comment\n'''\n"},
+		{"ruby interpolation", ".rb", "puts \"#{x}\" # c\n", "puts \"#{x}\"\n"},
+		// no safe lexer: left as it is
+		{"yaml", ".yaml", "url: https://example.com/#readme # c\n", "url: https://example.com/#readme # c\n"},
+		{"javascript regex", ".js", "const re = /\\/*/;\nlet x = 1; // c\n", "const re = /\\/*/;\nlet x = 1; // c\n"},
+		{"typescript", ".ts", "let x = 1; // c\n", "let x = 1; // c\n"},
+		{"unknown", ".txt", "# heading\n", "# heading\n"},
+		{"go that does not scan", ".go", "package p\nvar s = \"open\n// c\n", "package p\nvar s = \"open\n// c\n"},
+	}
+	for _, tt := range tests {
+		if got := stripComments(tt.in, tt.ext, commentSyntaxFor(tt.ext)); got != tt.want {
+			t.Errorf("%s: stripComments(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDropLicenseHeader(t *testing.T) {
+	tests := []struct {
+		name, ext, in, want string
+	}{
+		{"go license", ".go", "// Copyright 2024 The Authors.\n// Licensed under MIT.\n\npackage p\n", "package p\n"},
+		{"go package doc", ".go", "// Package p does x.\npackage p\n", "// Package p does x.\npackage p\n"},
+		{"c block", ".c", "/*\n * SPDX-License-Identifier: GPL-2.0\n */\n#include <x.h>\n", "#include <x.h>\n"},
+		{"shell after shebang", ".sh", "#!/bin/sh\n# Copyright 2024 X\necho hi\n", "#!/bin/sh\necho hi\n"},
+		{"not at the top", ".c", "int x;\n/* Copyright 2024 */\n", "int x;\n/* Copyright 2024 */\n"},
+	}
+	for _, tt := range tests {
+		if got := dropLicenseHeader(tt.in, tt.ext, commentSyntaxFor(tt.ext)); got != tt.want {
+			t.Errorf("%s: dropLicenseHeader(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWhitespaceTransforms(t *testing.T) {
+	if got, want := trimTrailingWhitespace("a \t\r\nb  \nc "), "a\r\nb\nc"; got != want {
+		t.Errorf("trimTrailingWhitespace = %q, want %q", got, want)
+	}
+	if got, want := collapseBlankLines("a\n\n\n\nb\n \n\t\r\nc\n"), "a\n\nb\n\nc\n"; got != want {
+		t.Errorf("collapseBlankLines = %q, want %q", got, want)
+	}
+}
+
+func TestContentTransformer(t *testing.T) {
+	if tr, err := newContentTransformer(map[string][]string{"go": {" "}}); tr != nil || err != nil {
+		t.Errorf("empty configuration = %v, %v; want nil, nil", tr, err)
+	}
+	if _, err := newContentTransformer(map[string][]string{"go": {"minify"}}); err == nil {
+		t.Errorf("unknown transform accepted")
+	}
+
+	tr, err := newContentTransformer(map[string][]string{
+		"":   {transformCollapseBlank},
+		"GO": {transformStripComments, transformDropLicenseHeader},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, results := tr.apply("a/b.go", "// Copyright X\n\npackage b\n\n\n// doc\nfunc F() {}\n")
+	if want := "package b\n\nfunc F() {}\n"; got != want {
+		t.Errorf("apply = %q, want %q", got, want)
+	}
+	var names []string
+	for _, res := range results {
+		names = append(names, res.name)
+	}
+	// the order is contentTransformOrder, not the configured one
+	if want := []string{transformDropLicenseHeader, transformStripComments, transformCollapseBlank}; !reflect.DeepEqual(names, want) {
+		t.Errorf("applied transforms = %v, want %v", names, want)
+	}
+	if got, _ := tr.apply("README.md", "# title\n\n\n\ntext\n"); got != "# title\n\ntext\n" {
+		t.Errorf("apply to another extension = %q", got)
+	}
+}
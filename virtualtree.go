@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- virtual trees ---
+
+// a root that is not a folder on disk, such as an archive (see archive.go) or a folder
+// as it was at a git ref (see gitref.go), is read through a virtualTree: every entry is
+// listed up front with its size and opened on demand. entries are addressed like files
+// below the root, <root path>/<entry path>, so the walkers, the ignore rules, the
+// selection and the readers relate them to the root exactly like the files of a folder.
+// nothing of a virtual tree is written to disk.
+
+// virtualFile is one file or symbolic link of a virtual tree.
+type virtualFile struct {
+	size    int64
+	modTime time.Time
+	link    string                        // target of a symbolic link; links are listed, never read
+	open    func() (io.ReadCloser, error) // nil when the content is not available
+	object  string                        // the blob of a file at a git ref, see gitref.go
+}
+
+// virtualTree holds the entries of a virtual root by slash path.
+type virtualTree struct {
+	root  string                  // absolute path the entries are placed below
+	files map[string]*virtualFile // by slash path
+	dirs  map[string][]string     // names of the entries of every directory, "." for the top
+
+	ref, commit string         // the git ref and the commit it resolved to, for roots at a ref (see gitref.go)
+	blobs       *gitBlobReader // reads the blobs during a generation, see forRun
+}
+
+var errVirtualContentUnavailable = errors.New("content not available")
+
+func newVirtualTree(root string) *virtualTree {
+	return &virtualTree{root: root, files: make(map[string]*virtualFile), dirs: map[string][]string{".": nil}}
+}
+
+// cleanVirtualPath turns an entry name into a slash path below the top of the tree. ok
+// is false for the top itself and for names that climb out of it.
+func cleanVirtualPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	p := strings.TrimPrefix(path.Clean("/"+name), "/")
+	return p, p != ""
+}
+
+// addDir adds the directory p and its parents.
+func (t *virtualTree) addDir(p string) {
+	for p != "." {
+		if _, ok := t.dirs[p]; ok {
+			return
+		}
+		t.dirs[p] = nil
+		if _, ok := t.files[p]; ok {
+			delete(t.files, p) // a directory of the same name wins, it is listed already
+			return
+		}
+		parent := path.Dir(p)
+		t.dirs[parent] = append(t.dirs[parent], path.Base(p))
+		p = parent
+	}
+}
+
+// addFile adds the file p, replacing an earlier entry with the same path.
+func (t *virtualTree) addFile(p string, f *virtualFile) {
+	if _, ok := t.dirs[p]; ok {
+		return // a directory of the same name wins
+	}
+	if _, ok := t.files[p]; !ok {
+		parent := path.Dir(p)
+		t.addDir(parent)
+		t.dirs[parent] = append(t.dirs[parent], path.Base(p))
+	}
+	t.files[p] = f
+}
+
+// rel returns the slash path below the top of the tree of absPath.
+func (t *virtualTree) rel(absPath string) (string, error) {
+	rel, err := filepath.Rel(t.root, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: "open", Path: absPath, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// open opens the file at absPath.
+func (t *virtualTree) open(absPath string) (io.ReadCloser, error) {
+	rel, err := t.rel(absPath)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := t.files[rel]
+	switch {
+	case !ok:
+		return nil, &fs.PathError{Op: "open", Path: absPath, Err: fs.ErrNotExist}
+	case f.object != "" && t.blobs != nil:
+		data, err := t.blobs.read(f.object)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: absPath, Err: err}
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	case f.open == nil:
+		return nil, &fs.PathError{Op: "open", Path: absPath, Err: errVirtualContentUnavailable}
+	}
+	return f.open()
+}
+
+// readFile returns the content of the file at absPath.
+func (t *virtualTree) readFile(absPath string) ([]byte, error) {
+	r, err := t.open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// open opens the file of a context entry, on disk or in its virtual tree.
+func (f contextFileEntry) open() (io.ReadCloser, error) {
+	if f.tree != nil {
+		return f.tree.open(f.absPath)
+	}
+	return os.Open(f.absPath)
+}
+
+// readFile returns the content of the file of a context entry.
+func (f contextFileEntry) readFile() ([]byte, error) {
+	if f.tree != nil {
+		return f.tree.readFile(f.absPath)
+	}
+	return os.ReadFile(f.absPath)
+}
+
+// virtualTreeCache keeps the trees of the last few virtual roots, so listing and
+// regenerating do not read them again. an entry is reused while its stamp (what
+// identifies the content, e.g. size and mtime of an archive) is unchanged.
+type virtualTreeCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedVirtualTree // by key, e.g. the path of an archive
+	order   []string                      // keys, least recently used first
+}
+
+type cachedVirtualTree struct {
+	stamp string
+	tree  *virtualTree
+}
+
+// virtualTreesKept bounds the cached trees.
+const virtualTreesKept = 4
+
+func newVirtualTreeCache() *virtualTreeCache {
+	return &virtualTreeCache{entries: make(map[string]*cachedVirtualTree)}
+}
+
+// get returns the cached tree for key when its stamp matches, otherwise it loads and
+// caches it. it is nil-safe: without a cache every call loads the tree.
+func (c *virtualTreeCache) get(key, stamp string, load func() (*virtualTree, error)) (*virtualTree, error) {
+	if c == nil {
+		return load()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	if e, ok := c.entries[key]; ok && e.stamp == stamp {
+		c.order = append(c.order, key)
+		return e.tree, nil
+	}
+	delete(c.entries, key)
+	tree, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = &cachedVirtualTree{stamp: stamp, tree: tree}
+	c.order = append(c.order, key)
+	for len(c.order) > virtualTreesKept {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return tree, nil
+}
+
+// rootTree returns the virtual tree of a root that is an archive or at a git ref, nil
+// for a folder.
+func (a *App) rootTree(root WorkspaceRoot) (*virtualTree, error) {
+	switch {
+	case root.Ref != "":
+		return a.openGitRefRoot(root)
+	case isArchiveRoot(root.Path):
+		return a.openArchiveTree(root.Path)
+	}
+	return nil, nil
+}
+
+// rootWalker returns the walker of a root, reading the entries of its virtual tree when
+// it has one.
+func (a *App) rootWalker(root WorkspaceRoot) (*symlinkWalker, error) {
+	walker, err := newSymlinkWalker(root.Path, a.currentSymlinkPolicy(), a.hardExcludedDirSet(root.Path))
+	if err != nil {
+		return nil, err
+	}
+	walker.tree, err = a.rootTree(root)
+	return walker, err
+}
+
+// virtualInfo describes an entry of a virtual tree for walkEntry.stat.
+type virtualInfo struct {
+	name  string
+	file  *virtualFile // nil for directories
+	isDir bool
+}
+
+func (i virtualInfo) Name() string { return i.name }
+func (i virtualInfo) IsDir() bool  { return i.isDir }
+func (i virtualInfo) Sys() any     { return nil }
+
+func (i virtualInfo) Size() int64 {
+	if i.file == nil {
+		return 0
+	}
+	return i.file.size
+}
+
+func (i virtualInfo) ModTime() time.Time {
+	if i.file == nil {
+		return time.Time{}
+	}
+	return i.file.modTime
+}
+
+func (i virtualInfo) Mode() fs.FileMode {
+	switch {
+	case i.isDir:
+		return fs.ModeDir | 0555
+	case i.file.link != "":
+		return fs.ModeSymlink | 0444
+	}
+	return 0444
+}
+
+// readVirtualDir is symlinkWalker.readDir for a virtual tree. links are listed with
+// their target under the show and follow policies but never followed.
+func (w *symlinkWalker) readVirtualDir(dirPath string) ([]walkEntry, error) {
+	rel, err := w.tree.rel(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	names, ok := w.tree.dirs[rel]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	names = append([]string(nil), names...)
+	sort.Strings(names) // directory order of os.ReadDir
+	result := make([]walkEntry, 0, len(names))
+	for _, name := range names {
+		e := walkEntry{name: name, path: filepath.Join(dirPath, name)}
+		entryPath := path.Join(rel, name)
+		if _, isDir := w.tree.dirs[entryPath]; isDir {
+			if w.hiddenDirs[name] {
+				continue
+			}
+			e.isDir, e.follow = true, true
+			e.info = virtualInfo{name: name, isDir: true}
+			result = append(result, e)
+			continue
+		}
+		f := w.tree.files[entryPath]
+		e.info = virtualInfo{name: name, file: f}
+		if f.link != "" {
+			if w.policy == symlinkPolicySkip {
+				continue
+			}
+			e.isLink, e.linkTarget = true, f.link
+		} else {
+			e.follow = true
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
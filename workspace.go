@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- multi-root workspaces ---
+
+// a workspace is a list of named roots, e.g. a backend repository and a shared
+// protobuf repository side by side on disk. the context holds one tree per root, and
+// every path in it (tree, <file> blocks, excluded paths, include patterns, pins) starts
+// with the alias of its root: "proto/api/v1/user.proto". each root has its own git
+// ignore rules, git status and watcher.
+//
+// a single project is a workspace of one root without an alias: its paths carry no
+// prefix and its tree starts with the folder name, exactly as before workspaces.
+
+// WorkspaceRoot is one folder of a workspace.
+type WorkspaceRoot struct {
+	Alias string `json:"alias"`         // prefix of the root's paths; empty only for a single project
+	Path  string `json:"path"`          // absolute
+	Ref   string `json:"ref,omitempty"` // git ref to read the root at instead of its working tree, see gitref.go
+}
+
+// projectRoots is the workspace of a single project folder.
+func projectRoots(rootDir string) []WorkspaceRoot {
+	return []WorkspaceRoot{{Path: rootDir}}
+}
+
+// isWorkspace reports whether roots are a multi-root workspace rather than a project.
+func isWorkspace(roots []WorkspaceRoot) bool {
+	return len(roots) > 0 && roots[0].Alias != ""
+}
+
+// validateWorkspace checks the aliases and paths of a workspace and returns the roots
+// with cleaned paths.
+func validateWorkspace(roots []WorkspaceRoot) ([]WorkspaceRoot, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("the workspace has no roots")
+	}
+	if len(roots) == 1 && roots[0].Alias == "" {
+		return []WorkspaceRoot{{Path: filepath.Clean(roots[0].Path), Ref: strings.TrimSpace(roots[0].Ref)}}, nil
+	}
+	aliases := make(map[string]bool, len(roots))
+	paths := make(map[string]bool, len(roots))
+	result := make([]WorkspaceRoot, len(roots))
+	for i, root := range roots {
+		alias := strings.TrimSpace(root.Alias)
+		switch {
+		case alias == "":
+			return nil, fmt.Errorf("workspace root %s has no alias", root.Path)
+		case alias == "." || alias == ".." || strings.ContainsAny(alias, `/\`):
+			return nil, fmt.Errorf("invalid workspace alias %q: it must be a plain name", alias)
+		case aliases[strings.ToLower(alias)]:
+			return nil, fmt.Errorf("workspace alias %q is used twice", alias)
+		}
+		if !filepath.IsAbs(root.Path) {
+			return nil, fmt.Errorf("workspace root %q must be an absolute path", root.Path)
+		}
+		path := filepath.Clean(root.Path)
+		if paths[path] {
+			return nil, fmt.Errorf("workspace root %s is listed twice", path)
+		}
+		aliases[strings.ToLower(alias)] = true
+		paths[path] = true
+		result[i] = WorkspaceRoot{Alias: alias, Path: path, Ref: strings.TrimSpace(root.Ref)}
+	}
+	return result, nil
+}
+
+// treeLabel is the name of the root line of the root's tree.
+func (r WorkspaceRoot) treeLabel() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	return filepath.Base(r.Path)
+}
+
+// relPath returns the context path (os-specific, alias first) of path below the root.
+func (r WorkspaceRoot) relPath(path string) string {
+	rel, _ := filepath.Rel(r.Path, path)
+	return r.prefixed(rel)
+}
+
+// prefixed puts the alias in front of a path relative to the root.
+func (r WorkspaceRoot) prefixed(rel string) string {
+	if r.Alias == "" {
+		return rel
+	}
+	if rel == "." {
+		return r.Alias
+	}
+	return filepath.Join(r.Alias, rel)
+}
+
+// workspaceKey identifies a workspace, e.g. for the content cache.
+func workspaceKey(roots []WorkspaceRoot) string {
+	parts := make([]string, len(roots))
+	for i, r := range roots {
+		parts[i] = r.Alias + "=" + r.Path
+		if r.Ref != "" {
+			parts[i] += "@" + r.Ref
+		}
+	}
+	return strings.Join(parts, string(os.PathListSeparator))
+}
+
+// prefixRelPaths moves a root's tree below its alias.
+func prefixRelPaths(nodes []*FileNode, root WorkspaceRoot) {
+	for _, node := range nodes {
+		node.RelPath = root.prefixed(node.RelPath)
+		prefixRelPaths(node.Children, root)
+	}
+}
+
+// listRoot builds the file tree of one root with its own git ignore rules and git
+// status. the returned root node is named after the alias in a workspace, followed by
+// the ref and the short commit for a root at a git ref.
+func (a *App) listRoot(root WorkspaceRoot) (*FileNode, *gitIgnoreMatcher, error) {
+	walker, err := a.rootWalker(root)
+	if err != nil {
+		return &FileNode{Name: root.treeLabel(), Path: root.Path, RelPath: root.prefixed("."), IsDir: true}, nil, err
+	}
+	var gitIgn *gitIgnoreMatcher
+	if walker.tree != nil {
+		gitIgn = newVirtualGitIgnoreMatcher(walker.tree)
+	} else {
+		gitIgn = newGitIgnoreMatcher(root.Path)
+	}
+	if gitIgn.repoTop != root.Path {
+		a.logDebugf("listfiles: %s is inside git repository %s", root.Path, gitIgn.repoTop)
+	}
+	a.logDebugf("listfiles: %d global git ignore rules loaded", len(gitIgn.global))
+
+	rootNode := &FileNode{
+		Name:         root.treeLabel() + walker.tree.shortRefLabel(),
+		Path:         root.Path,
+		RelPath:      root.prefixed("."),
+		IsDir:        true,
+		IsGitignored: false, // root itself is not gitignored by default
+		// iscustomignored for root is also false by default, specific patterns would be needed
+		IsCustomIgnored: a.currentCustomIgnorePatterns != nil && a.currentCustomIgnorePatterns.MatchesPath("."),
+	}
+
+	children, err := a.buildTreeRecursive(context.TODO(), walker, root.Path, root.Path, gitIgn, a.currentCustomIgnorePatterns, 0, false, false)
+	if err != nil {
+		return rootNode, gitIgn, fmt.Errorf("error building children tree for %s: %w", root.Path, err)
+	}
+	rootNode.Children = children
+
+	// git status is best effort: plain folders and a missing git binary just show none.
+	// the status of the working tree does not apply to a root at a git ref
+	if root.Ref == "" {
+		if changes, _, err := gitChangedFiles(context.TODO(), root.Path, ""); err == nil {
+			annotateGitStatus(rootNode.Children, changes)
+		} else if !errors.Is(err, errNotAGitRepository) {
+			a.logDebugf("listfiles: git status unavailable for %s: %v", root.Path, err)
+		}
+	}
+	prefixRelPaths(rootNode.Children, root)
+	return rootNode, gitIgn, nil
+}
+
+// ListWorkspaceFiles lists the files of every root of a workspace: one root node per
+// root, named after its alias, with alias-prefixed relative paths.
+func (a *App) ListWorkspaceFiles(roots []WorkspaceRoot) ([]*FileNode, error) {
+	roots, err := validateWorkspace(roots)
+	if err != nil {
+		return nil, err
+	}
+	a.logDebugf("listworkspacefiles called for %d roots", len(roots))
+	nodes := make([]*FileNode, 0, len(roots))
+	matchers := make(map[string]*gitIgnoreMatcher, len(roots))
+	for _, root := range roots {
+		node, gitIgn, err := a.listRoot(root)
+		matchers[root.Path] = gitIgn
+		nodes = append(nodes, node)
+		if err != nil {
+			a.projectGitignores = matchers
+			return nodes, err
+		}
+	}
+	a.projectGitignores = matchers // the per-root matchers for the watcher
+	return nodes, nil
+}
+
+// listWorkspace lists a project with ListFiles and a workspace, or a project at a git
+// ref, with ListWorkspaceFiles.
+func (a *App) listWorkspace(roots []WorkspaceRoot) ([]*FileNode, error) {
+	if !isWorkspace(roots) && roots[0].Ref == "" {
+		return a.ListFiles(roots[0].Path)
+	}
+	return a.ListWorkspaceFiles(roots)
+}
+
+// RequestWorkspaceContextGeneration generates one context for all roots of a
+// workspace. excludedPaths are alias-prefixed like the paths of ListWorkspaceFiles.
+func (a *App) RequestWorkspaceContextGeneration(roots []WorkspaceRoot, excludedPaths []string, opts ContextOptions) {
+	roots, err := validateWorkspace(roots)
+	if err != nil {
+		a.emitEvent("shotgunContextError", err.Error())
+		return
+	}
+	if a.contextGenerator == nil {
+		a.logError("contextgenerator not initialized")
+		a.emitEvent("shotgunContextError", "internal error: contextgenerator not initialized")
+		return
+	}
+	a.contextGenerator.requestShotgunContextGenerationInternal(roots, excludedPaths, opts)
+}
+
+// StartWorkspaceWatcher watches every root of a workspace. changes are reported with
+// projectFilesChanged and the path of the first root.
+func (a *App) StartWorkspaceWatcher(roots []WorkspaceRoot) error {
+	roots, err := validateWorkspace(roots)
+	if err != nil {
+		return err
+	}
+	a.logInfof("startworkspacewatcher called for %d roots", len(roots))
+	if a.fileWatcher == nil {
+		return fmt.Errorf("file watcher not initialized")
+	}
+	return a.fileWatcher.Start(roots)
+}
+
+// rootsAtRef reads every root at the git ref given with -ref; "" keeps the working trees.
+func rootsAtRef(roots []WorkspaceRoot, ref string) []WorkspaceRoot {
+	for i := range roots {
+		roots[i].Ref = strings.TrimSpace(ref)
+	}
+	return roots
+}
+
+// parseCLIRoots turns the command line root arguments into a workspace: a single
+// directory is a project, even when its name contains "=" (./build=2); otherwise
+// every argument must be alias=dir.
+func parseCLIRoots(args []string) ([]WorkspaceRoot, error) {
+	if len(args) == 1 {
+		root, err := resolveCLIRoot(args[0])
+		if err == nil {
+			return projectRoots(root), nil
+		}
+		if !strings.Contains(args[0], "=") {
+			return nil, err
+		}
+	}
+	roots := make([]WorkspaceRoot, 0, len(args))
+	for _, arg := range args {
+		alias, dir, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("workspace root %q must be given as alias=dir", arg)
+		}
+		path, err := resolveCLIRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, WorkspaceRoot{Alias: alias, Path: path})
+	}
+	return validateWorkspace(roots)
+}